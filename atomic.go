@@ -0,0 +1,69 @@
+package transcoder
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// atomicOutput - the output path to write atomically, empty unless the preset opts in
+func (job *Job) atomicOutput() string {
+	if job.Preset == nil || job.Preset.AtomicOutput == "" {
+		return ""
+	}
+	return job.Params[job.Preset.AtomicOutput]
+}
+
+// tempOutputDir - hidden directory next to the output, on the same filesystem so files can be renamed into place
+func (job *Job) tempOutputDir() string {
+	return filepath.Join(filepath.Dir(job.atomicOutput()), ".transcoder-"+job.ID.String())
+}
+
+// renderParams - job params with the atomic output moved into tempOutputDir
+func (job *Job) renderParams() JobParams {
+	output := job.atomicOutput()
+	if output == "" {
+		return job.Params
+	}
+	params := make(JobParams, len(job.Params))
+	for name, value := range job.Params {
+		params[name] = value
+	}
+	params[job.Preset.AtomicOutput] = filepath.Join(job.tempOutputDir(), filepath.Base(output))
+	return params
+}
+
+// createTempOutput - start the attempt with an empty tempOutputDir
+func (job *Job) createTempOutput() error {
+	dir := job.tempOutputDir()
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("clearing temporary output %w", err)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("creating temporary output %w", err)
+	}
+	return nil
+}
+
+// commitOutput - rename everything written to tempOutputDir into the output directory, replacing existing files
+func (job *Job) commitOutput() error {
+	dir := job.tempOutputDir()
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("reading temporary output %w", err)
+	}
+	for _, file := range files {
+		if err := os.Rename(filepath.Join(dir, file.Name()), filepath.Join(filepath.Dir(dir), file.Name())); err != nil {
+			return fmt.Errorf("moving output into place %w", err)
+		}
+	}
+	return os.Remove(dir)
+}
+
+// removeTempOutput - delete whatever an attempt left in tempOutputDir
+func (job *Job) removeTempOutput() {
+	if err := os.RemoveAll(job.tempOutputDir()); err != nil {
+		job.appendErrOutput(fmt.Sprintf("removing temporary output %v", err))
+	}
+}
@@ -0,0 +1,145 @@
+package transcoder
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"regexp"
+)
+
+// ErrorCode - typed reason a job failed
+type ErrorCode string
+
+const (
+	ErrorCodeNone               ErrorCode = ""
+	ErrorCodeUnknown            ErrorCode = "unknown"
+	ErrorCodeNoSuchFile         ErrorCode = "noSuchFile"
+	ErrorCodeInvalidData        ErrorCode = "invalidData"
+	ErrorCodeUnknownEncoder     ErrorCode = "unknownEncoder"
+	ErrorCodeDiskFull           ErrorCode = "diskFull"
+	ErrorCodePermission         ErrorCode = "permissionDenied"
+	ErrorCodeConversionFailed   ErrorCode = "conversionFailed"
+	ErrorCodeKilled             ErrorCode = "killed"
+	ErrorCodeTimedOut           ErrorCode = "timedOut"
+	ErrorCodeCanceled           ErrorCode = "canceled"
+	ErrorCodeCrashed            ErrorCode = "crashed" // terminated by a signal nobody sent
+	ErrorCodeBadParams          ErrorCode = "badParams"
+	ErrorCodePanic              ErrorCode = "panic" // recovered panic, see PanicError
+	ErrorCodeHookFailed         ErrorCode = "hookFailed"
+	ErrorCodeVerificationFailed ErrorCode = "verificationFailed"
+)
+
+// Transient - true if running the same job again might succeed
+func (code ErrorCode) Transient() bool {
+	switch code {
+	case ErrorCodeDiskFull, ErrorCodeTimedOut, ErrorCodeCrashed, ErrorCodeUnknown:
+		return true
+	}
+	return false
+}
+
+// Classifier - map a failed job to an ErrorCode
+// Return ErrorCodeNone to let the next classifier decide
+type Classifier interface {
+	Classify(job *Job, err error) ErrorCode
+}
+
+// ClassifierFunc - adapter to use a plain func as a Classifier
+type ClassifierFunc func(job *Job, err error) ErrorCode
+
+func (f ClassifierFunc) Classify(job *Job, err error) ErrorCode {
+	return f(job, err)
+}
+
+// Classifiers - try each Classifier in order until one returns a code
+type Classifiers []Classifier
+
+func (c Classifiers) Classify(job *Job, err error) ErrorCode {
+	for _, classifier := range c {
+		if code := classifier.Classify(job, err); code != ErrorCodeNone {
+			return code
+		}
+	}
+	return ErrorCodeNone
+}
+
+// ErrOutputRule - ErrorCode for stderr lines matching Pattern
+type ErrOutputRule struct {
+	Pattern *regexp.Regexp
+	Code    ErrorCode
+}
+
+// ErrOutputClassifier - match rules against stderr in order, the first rule matching any line wins
+// Lines are tried last first since ffmpeg prints the cause near the end
+type ErrOutputClassifier []ErrOutputRule
+
+func (rules ErrOutputClassifier) Classify(job *Job, err error) ErrorCode {
+	errOutput := job.ErrOutput()
+	for _, rule := range rules {
+		for i := len(errOutput) - 1; i >= 0; i-- {
+			if rule.Pattern.MatchString(errOutput[i]) {
+				return rule.Code
+			}
+		}
+	}
+	return ErrorCodeNone
+}
+
+// FFmpegErrOutputRules - common ffmpeg failures. Order matters, the generic "Conversion failed!" ffmpeg ends
+// every runtime failure with comes last so it only applies when no specific cause was printed
+var FFmpegErrOutputRules = ErrOutputClassifier{
+	{regexp.MustCompile(`No such file or directory`), ErrorCodeNoSuchFile},
+	{regexp.MustCompile(`Invalid data found when processing input`), ErrorCodeInvalidData},
+	{regexp.MustCompile(`Unknown encoder|Encoder not found|Unknown decoder|Decoder not found`), ErrorCodeUnknownEncoder},
+	{regexp.MustCompile(`No space left on device`), ErrorCodeDiskFull},
+	{regexp.MustCompile(`Permission denied`), ErrorCodePermission},
+	{regexp.MustCompile(`Conversion failed!`), ErrorCodeConversionFailed},
+}
+
+// statusClassifier - codes from how the process ended rather than what it printed
+var statusClassifier = ClassifierFunc(func(job *Job, err error) ErrorCode {
+	var missing *MissingParamError
+	var templateErr *TemplateError
+	var panicErr *PanicError
+	var hookErr *HookError
+	var verifyErr *VerificationError
+	switch {
+	case errors.As(err, &panicErr):
+		return ErrorCodePanic
+	case errors.As(err, &missing), errors.As(err, &templateErr), errors.Is(err, ErrNoVerifyOutput), errors.Is(err, ErrNoProbeInput):
+		return ErrorCodeBadParams
+	case job.status() == JobStatusTimedOut:
+		return ErrorCodeTimedOut
+	case job.status() == JobStatusCanceled:
+		return ErrorCodeCanceled
+	case errors.Is(err, ErrKilled):
+		return ErrorCodeKilled
+	case errors.As(err, &hookErr):
+		return ErrorCodeHookFailed
+	case errors.As(err, &verifyErr):
+		return ErrorCodeVerificationFailed
+	case job.Exit.Signal != "":
+		return ErrorCodeCrashed
+	case errors.Is(err, exec.ErrNotFound), errors.Is(err, os.ErrNotExist):
+		return ErrorCodeNoSuchFile
+	case errors.Is(err, os.ErrPermission):
+		return ErrorCodePermission
+	}
+	return ErrorCodeNone
+})
+
+// DefaultClassifier - used when a Job has no Classifier
+var DefaultClassifier Classifier = Classifiers{statusClassifier, FFmpegErrOutputRules}
+
+// classify - set ErrorCode for a failed run
+func (job *Job) classify(err error) {
+	classifier := job.Classifier
+	if classifier == nil {
+		classifier = DefaultClassifier
+	}
+	code := classifier.Classify(job, err)
+	if code == ErrorCodeNone {
+		code = ErrorCodeUnknown
+	}
+	job.setErrorCode(code)
+}
@@ -1,10 +1,15 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"io/ioutil"
 	"log"
+	"os"
+	"os/signal"
 	"path"
+	"syscall"
 
 	"github.com/palmdalian/transcoder"
 )
@@ -13,6 +18,11 @@ const (
 	WorkerNum = 2
 )
 
+var (
+	logDir string
+	dryRun bool
+)
+
 var preset = &transcoder.Preset{
 	Path: "ffmpeg",
 	Args: []string{"-y", "-progress", "-", "-nostats", "-i", "{{input}}", "{{output}}"},
@@ -24,6 +34,8 @@ func main() {
 	var output string
 	flag.StringVar(&input, "i", "", "input directory")
 	flag.StringVar(&output, "o", "", "output directory")
+	flag.BoolVar(&dryRun, "dry-run", false, "print commands without running them")
+	flag.StringVar(&logDir, "log-dir", "", "optional directory for full job logs")
 	flag.Parse()
 
 	if input == "" {
@@ -41,25 +53,69 @@ func main() {
 		log.Fatal(err)
 	}
 
+	// Stop the running job with its StopPolicy instead of orphaning ffmpeg
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		sig := <-shutdownSignal()
+		log.Printf("Got %v, stopping", sig)
+		cancel()
+	}()
+
 	for _, f := range files {
+		if ctx.Err() != nil {
+			break
+		}
 		if f.IsDir() {
 			continue
 		}
-		err := runJob(path.Join(input, f.Name()), path.Join(output, f.Name()))
+		err := runJob(ctx, path.Join(input, f.Name()), path.Join(output, f.Name()))
 		if err != nil {
 			log.Println(err)
 		}
 	}
 }
 
-func runJob(input, output string) error {
+func runJob(ctx context.Context, input, output string) error {
 	params := map[string]string{
 		"input":  input,
 		"output": output,
 	}
 
-	job := transcoder.NewJob(preset, params)
+	job, err := transcoder.NewJob(preset, params)
+	if err != nil {
+		return err
+	}
+	if dryRun {
+		command, err := job.Command()
+		if err != nil {
+			return err
+		}
+		fmt.Println(command)
+		return nil
+	}
+	if logDir != "" {
+		job.LogPath = path.Join(logDir, job.ID.String()+".log")
+	}
 	log.Printf("Running %v %v : %v", job.ID, input, output)
-	err := job.Run()
-	return err
+	events, _ := job.Subscribe()
+	go printProgress(events)
+	return job.RunContext(ctx)
+}
+
+func printProgress(events <-chan transcoder.JobEvent) {
+	for event := range events {
+		if event.Type != transcoder.JobEventProgress {
+			continue
+		}
+		p := event.Progress
+		log.Printf("%v %.1f%% speed %.2fx eta %.0fs", event.JobID, p.Percent, p.Speed, p.ETA)
+	}
+}
+
+// shutdownSignal - receives SIGTERM or interrupt
+func shutdownSignal() <-chan os.Signal {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	return signals
 }
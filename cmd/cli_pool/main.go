@@ -1,20 +1,28 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"os"
+	"os/signal"
 	"path"
 	"sync"
+	"syscall"
+	"time"
 
 	"github.com/palmdalian/transcoder"
 )
 
 const (
-	WorkerNum = 2
+	WorkerNum       = 2
+	ShutdownTimeout = 30 * time.Second // Running jobs are stopped in time to exit before this
 )
 
+var dryRun bool
+
 var preset = &transcoder.Preset{
 	Path: "ffmpeg",
 	Args: []string{"-y", "-progress", "-", "-nostats", "-i", "{{input}}", "{{output}}"},
@@ -26,6 +34,7 @@ func main() {
 	var output string
 	flag.StringVar(&input, "i", "", "input directory")
 	flag.StringVar(&output, "o", "", "output directory")
+	flag.BoolVar(&dryRun, "dry-run", false, "print commands without running them")
 	flag.Parse()
 
 	if input == "" {
@@ -38,13 +47,10 @@ func main() {
 		log.Fatalf("Output directory must be present")
 	}
 
-	jobQueue := make(chan *transcoder.Job, 100)
-	jobUpdatesChan := make(chan *transcoder.JobStatus, 100)
-	go printUpdates(jobUpdatesChan)
-	for i := 0; i < WorkerNum; i++ {
-		worker := transcoder.NewWorker(jobQueue, jobUpdatesChan)
-		worker.Name = fmt.Sprintf("Worker%d", i)
-	}
+	events := transcoder.NewEventBus()
+	updates, _ := events.Subscribe(transcoder.SubscribeOptions{Types: transcoder.StatusEventTypes})
+	go printUpdates(updates)
+	pool := transcoder.NewPool(WorkerNum, events)
 
 	files, err := ioutil.ReadDir(input)
 	if err != nil {
@@ -57,26 +63,65 @@ func main() {
 			continue
 		}
 		wg.Add(1)
-		go submitJob(wg, jobQueue, path.Join(input, f.Name()), path.Join(output, f.Name()))
+		go submitJob(wg, pool, path.Join(input, f.Name()), path.Join(output, f.Name()))
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case sig := <-shutdownSignal():
+		log.Printf("Got %v, draining jobs...", sig)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+	if err := pool.ShutdownWithin(ctx); err != nil {
+		log.Printf("Stopped running jobs: %v", err)
 	}
-	wg.Wait()
 }
 
-func submitJob(wg *sync.WaitGroup, jobQueue chan *transcoder.Job, input, output string) {
+func submitJob(wg *sync.WaitGroup, pool *transcoder.Pool, input, output string) {
 	defer wg.Done()
 	params := map[string]string{
 		"input":  input,
 		"output": output,
 	}
 
-	job := transcoder.NewJob(preset, params)
+	job, err := transcoder.NewJob(preset, params)
+	if err != nil {
+		log.Printf("Skipping %v: %v", input, err)
+		return
+	}
+	if dryRun {
+		command, err := job.Command()
+		if err != nil {
+			log.Printf("Skipping %v: %v", input, err)
+			return
+		}
+		fmt.Println(command)
+		return
+	}
 	log.Printf("Submitting %v %v : %v", job.ID, input, output)
-	jobQueue <- job
+	if err = pool.Submit(job); err != nil {
+		log.Printf("Skipping %v: %v", input, err)
+		return
+	}
 	job.Wait()
 }
 
-func printUpdates(jobUpdatesChan chan *transcoder.JobStatus) {
-	for update := range jobUpdatesChan {
-		log.Printf("%v %v Status: %s %s", update.Job.ID, update.Job.Params, update.Status, update.Message)
+func printUpdates(updates <-chan transcoder.JobEvent) {
+	for update := range updates {
+		log.Printf("%v %v %s Status: %s %s", update.JobID, update.Job.Params, update.Type, update.Status, update.Message)
 	}
 }
+
+// shutdownSignal - receives SIGTERM or interrupt
+func shutdownSignal() <-chan os.Signal {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	return signals
+}
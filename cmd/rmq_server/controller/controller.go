@@ -2,6 +2,7 @@ package controller
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -14,18 +15,25 @@ import (
 )
 
 type Controller struct {
-	db             *gorm.DB
-	director       *queue.Director
-	jobUpdatesChan chan *transcoder.JobStatus
+	db       *gorm.DB
+	director *queue.Director
+	updates  <-chan transcoder.JobEvent
 }
 
-func NewController(db *gorm.DB, director *queue.Director, jobUpdatesChan chan *transcoder.JobStatus) *Controller {
+// NewController - saves every job status event published on events to db
+func NewController(db *gorm.DB, director *queue.Director, events *transcoder.EventBus) *Controller {
 	controller := &Controller{
-		db:             db,
-		director:       director,
-		jobUpdatesChan: jobUpdatesChan,
+		db:       db,
+		director: director,
+	}
+	if events != nil {
+		// Block rather than drop so every status change is saved
+		controller.updates, _ = events.Subscribe(transcoder.SubscribeOptions{
+			Overflow: transcoder.OverflowBlock,
+			Types:    transcoder.StatusEventTypes,
+		})
+		go controller.saveWorkerJobUpdates()
 	}
-	go controller.saveWorkerJobUpdates()
 	return controller
 }
 
@@ -40,8 +48,8 @@ func (c *Controller) DestroyQueue(w http.ResponseWriter, r *http.Request) {
 }
 
 func (c *Controller) saveWorkerJobUpdates() {
-	for jobStatus := range c.jobUpdatesChan {
-		job := jobStatus.Job
+	for event := range c.updates {
+		job := event.Job
 		if job == nil {
 			continue
 		}
@@ -69,8 +77,9 @@ func writeJSONResponse(w http.ResponseWriter, code int, body interface{}) {
 }
 
 type errResponse struct {
-	Code    int    `json:"code"`
-	Message string `json:"message"`
+	Code    int                     `json:"code"`
+	Message string                  `json:"message"`
+	Fields  []transcoder.FieldError `json:"fields,omitempty"`
 }
 
 func writeErrResponse(w http.ResponseWriter, code int, message string) {
@@ -86,6 +95,17 @@ func writeErrResponse(w http.ResponseWriter, code int, message string) {
 	w.Write(b)
 }
 
+// writeJobErrResponse - field-level errors for invalid params, otherwise a plain bad request
+func writeJobErrResponse(w http.ResponseWriter, err error) {
+	var validationErr *transcoder.ValidationError
+	if !errors.As(err, &validationErr) {
+		writeErrResponse(w, http.StatusBadRequest, fmt.Sprintf("creating job %v", err))
+		return
+	}
+	code := http.StatusUnprocessableEntity
+	writeJSONResponse(w, code, errResponse{Code: code, Message: "invalid params", Fields: validationErr.Fields})
+}
+
 func (c *Controller) sendToQueue(job *transcoder.Job) error {
 	err := c.db.Save(job).Error
 	if err != nil {
@@ -0,0 +1,72 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+const copyPresetID = "f12e777d-4666-484c-99b9-fd0ec24c9f3e"
+
+// newTestRouter - controller without a db or director, only requests rejected before queueing can be served
+func newTestRouter() *mux.Router {
+	c := NewController(nil, nil, nil)
+	r := mux.NewRouter()
+	r.HandleFunc("/presets/{presetID}/submit", c.SubmitPresetJob)
+	r.HandleFunc("/preset-groups/{presetGroupID}/submit", c.SubmitPresetGroupJob)
+	return r
+}
+
+func TestSubmitPresetJobInvalid(t *testing.T) {
+	r := newTestRouter()
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/presets/"+copyPresetID+"/submit", strings.NewReader(`{"params": {"output": "out", "extra": "x"}}`)))
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("code = %d, want %d: %s", w.Code, http.StatusUnprocessableEntity, w.Body)
+	}
+	resp := &errResponse{}
+	if err := json.Unmarshal(w.Body.Bytes(), resp); err != nil {
+		t.Fatal(err)
+	}
+	fields := map[string]bool{}
+	for _, f := range resp.Fields {
+		fields[f.Field] = true
+	}
+	if !fields["input"] || !fields["extra"] || len(fields) != 2 {
+		t.Errorf("Fields = %+v, want input and extra errors", resp.Fields)
+	}
+}
+
+func TestSubmitPresetGroupJobInvalid(t *testing.T) {
+	r := newTestRouter()
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/preset-groups/3d42ee9d-dfe2-4105-b0ab-abfbcbc0d795/submit", strings.NewReader(`{"params": {}}`)))
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("code = %d, want %d: %s", w.Code, http.StatusUnprocessableEntity, w.Body)
+	}
+}
+
+func TestSubmitPresetJobDryRun(t *testing.T) {
+	r := newTestRouter()
+
+	w := httptest.NewRecorder()
+	body := `{"params": {"input": "in.mov", "output": "out"}}`
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/presets/"+copyPresetID+"/submit?dryRun=true", strings.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("code = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	resp := &dryRunResponse{}
+	if err := json.Unmarshal(w.Body.Bytes(), resp); err != nil {
+		t.Fatal(err)
+	}
+	want := "ffmpeg -y -progress - -nostats -i in.mov -c copy out.mp4"
+	if got := resp.Command.String(); got != want {
+		t.Errorf("command = %q, want %q", got, want)
+	}
+}
@@ -32,7 +32,7 @@ func (c *Controller) GetJobs(w http.ResponseWriter, r *http.Request) {
 	params := r.URL.Query()
 	states := params["status"]
 	if len(states) == 0 {
-		states = []string{transcoder.JobStatusSubmitted, transcoder.JobStatusInProgress}
+		states = []string{transcoder.JobStatusSubmitted, transcoder.JobStatusInProgress, transcoder.JobStatusRetrying}
 	}
 
 	jobs := []*transcoder.Job{}
@@ -60,7 +60,7 @@ func (c *Controller) JobInfo(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if job.Status != transcoder.JobStatusInProgress {
-		stat := &transcoder.JobStatus{Status: job.Status, Message: "Job is not running", Job: job}
+		stat := transcoder.NewJobStatus(job, "Job is not running")
 		writeJSONResponse(w, http.StatusBadRequest, stat)
 		return
 	}
@@ -92,8 +92,8 @@ func (c *Controller) JobKill(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if job.Status != transcoder.JobStatusInProgress {
-		stat := &transcoder.JobStatus{Status: job.Status, Message: "Job is not running", Job: job}
+	if job.Status != transcoder.JobStatusInProgress && job.Status != transcoder.JobStatusRetrying {
+		stat := transcoder.NewJobStatus(job, "Job is not running")
 		writeJSONResponse(w, http.StatusBadRequest, stat)
 		return
 	}
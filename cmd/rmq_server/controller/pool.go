@@ -0,0 +1,33 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/palmdalian/transcoder"
+)
+
+// GetPool - worker pool size, queue length and per-worker stats
+func (c *Controller) GetPool(w http.ResponseWriter, r *http.Request) {
+	writeJSONResponse(w, http.StatusOK, c.director.Stats())
+}
+
+// ResizePool - start or stop workers with ?size=
+func (c *Controller) ResizePool(w http.ResponseWriter, r *http.Request) {
+	size, err := strconv.Atoi(r.URL.Query().Get("size"))
+	if err != nil {
+		writeErrResponse(w, http.StatusBadRequest, fmt.Sprintf("bad size %v", err))
+		return
+	}
+	if err = c.director.Resize(size); err != nil {
+		code := http.StatusBadRequest
+		if errors.Is(err, transcoder.ErrPoolClosed) {
+			code = http.StatusServiceUnavailable
+		}
+		writeErrResponse(w, code, fmt.Sprintf("resizing pool %v", err))
+		return
+	}
+	writeJSONResponse(w, http.StatusOK, c.director.Stats())
+}
@@ -1,10 +1,15 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
 	"os/user"
+	"syscall"
+	"time"
 
 	"github.com/palmdalian/transcoder"
 	"github.com/palmdalian/transcoder/cmd/rmq_server/controller"
@@ -17,9 +22,10 @@ import (
 )
 
 const (
-	WorkerNum = 2 // Set to zero to avoid running jobs
-	Port      = 3210
-	QueueName = "jobs"
+	WorkerNum       = 2 // Set to zero to avoid running jobs, /pool/resize changes it at runtime
+	Port            = 3210
+	QueueName       = "jobs"
+	ShutdownTimeout = 30 * time.Second // Running jobs are stopped in time to exit before this
 )
 
 func main() {
@@ -32,13 +38,13 @@ func main() {
 		Addrs: []string{"localhost:6379"},
 	})
 
-	jobUpdatesChan := make(chan *transcoder.JobStatus, 10)
-	director, err := queue.NewDirector(QueueName, WorkerNum, redisClient, jobUpdatesChan)
+	events := transcoder.NewEventBus()
+	director, err := queue.NewDirector(QueueName, WorkerNum, redisClient, events)
 	if err != nil {
 		log.Fatalf("Could not create director %v", err)
 	}
 
-	controller := controller.NewController(db, director, jobUpdatesChan)
+	controller := controller.NewController(db, director, events)
 
 	r := mux.NewRouter()
 	r.HandleFunc("/preset-groups/{presetGroupID}/submit", controller.SubmitPresetGroupJob)
@@ -48,16 +54,37 @@ func main() {
 	r.HandleFunc("/jobs/{jobID}/resubmit", controller.JobResubmit)
 	r.HandleFunc("/jobs/{jobID}/info", controller.JobInfo)
 	r.HandleFunc("/jobs/{jobID}/kill", controller.JobKill)
+	r.HandleFunc("/pool", controller.GetPool)
+	r.HandleFunc("/pool/resize", controller.ResizePool)
 	r.HandleFunc("/purge-ready", controller.PurgeReady)
 	r.HandleFunc("/destroy-queue", controller.DestroyQueue)
 	http.Handle("/", r)
 
-	log.Printf("Listening on :%d...\n", Port)
-	err = http.ListenAndServe(fmt.Sprintf(":%d", Port), http.DefaultServeMux)
-	if err != nil {
-		log.Fatal(err)
+	server := &http.Server{Addr: fmt.Sprintf(":%d", Port), Handler: http.DefaultServeMux}
+	go func() {
+		log.Printf("Listening on :%d...\n", Port)
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	sig := <-shutdownSignal()
+	log.Printf("Got %v, draining jobs...", sig)
+	ctx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+	if err := director.ShutdownWithin(ctx); err != nil {
+		log.Printf("Stopped running jobs: %v", err)
 	}
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Err shutting down server: %v", err)
+	}
+}
 
+// shutdownSignal - receives SIGTERM or interrupt
+func shutdownSignal() <-chan os.Signal {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	return signals
 }
 
 func setupDB() (*gorm.DB, error) {
@@ -1,7 +1,12 @@
 package main
 
 import (
+	"context"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/palmdalian/transcoder/queue"
 
@@ -9,8 +14,9 @@ import (
 )
 
 const (
-	WorkerNum = 2
-	QueueName = "jobs"
+	WorkerNum       = 2
+	QueueName       = "jobs"
+	ShutdownTimeout = 30 * time.Second // Running jobs are stopped in time to exit before this
 )
 
 func main() {
@@ -18,9 +24,24 @@ func main() {
 		Addrs: []string{"localhost:6379"},
 	})
 
-	if _, err := queue.NewDirector(QueueName, WorkerNum, redisClient, nil); err != nil {
+	director, err := queue.NewDirector(QueueName, WorkerNum, redisClient, nil)
+	if err != nil {
 		log.Fatalf("Could not create director %v", err)
 	}
 	log.Println("Listening for jobs...")
-	select {}
+
+	sig := <-shutdownSignal()
+	log.Printf("Got %v, draining jobs...", sig)
+	ctx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+	if err := director.ShutdownWithin(ctx); err != nil {
+		log.Printf("Stopped running jobs: %v", err)
+	}
+}
+
+// shutdownSignal - receives SIGTERM or interrupt
+func shutdownSignal() <-chan os.Signal {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	return signals
 }
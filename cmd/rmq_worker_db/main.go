@@ -1,9 +1,14 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"os"
+	"os/signal"
 	"os/user"
+	"syscall"
+	"time"
 
 	"github.com/palmdalian/transcoder"
 	"github.com/palmdalian/transcoder/queue"
@@ -15,8 +20,9 @@ import (
 )
 
 const (
-	WorkerNum = 2
-	QueueName = "jobs"
+	WorkerNum       = 2
+	QueueName       = "jobs"
+	ShutdownTimeout = 30 * time.Second // Running jobs are stopped in time to exit before this
 )
 
 func main() {
@@ -29,14 +35,41 @@ func main() {
 		Addrs: []string{"localhost:6379"},
 	})
 
-	jobUpdatesChan := make(chan *transcoder.JobStatus, 10)
-	_, err = queue.NewDirector(QueueName, WorkerNum, redisClient, jobUpdatesChan)
+	// Block rather than drop so every status change is saved
+	events := transcoder.NewEventBus()
+	updates, unsubscribe := events.Subscribe(transcoder.SubscribeOptions{
+		Overflow: transcoder.OverflowBlock,
+		Types:    transcoder.StatusEventTypes,
+	})
+	saved := make(chan struct{})
+	go func() {
+		saveWorkerJobUpdates(db, updates)
+		close(saved)
+	}()
+
+	director, err := queue.NewDirector(QueueName, WorkerNum, redisClient, events)
 	if err != nil {
 		log.Fatalf("Could not create director %v", err)
 	}
 	log.Println("Listening for jobs...")
-	go saveWorkerJobUpdates(db, jobUpdatesChan)
-	select {}
+
+	sig := <-shutdownSignal()
+	log.Printf("Got %v, draining jobs...", sig)
+	ctx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+	if err := director.ShutdownWithin(ctx); err != nil {
+		log.Printf("Stopped running jobs: %v", err)
+	}
+	// Workers have exited, save their last updates
+	unsubscribe()
+	<-saved
+}
+
+// shutdownSignal - receives SIGTERM or interrupt
+func shutdownSignal() <-chan os.Signal {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	return signals
 }
 
 func setupDB() (*gorm.DB, error) {
@@ -48,9 +81,9 @@ func setupDB() (*gorm.DB, error) {
 	return gorm.Open(postgres.Open(dsn), &gorm.Config{})
 }
 
-func saveWorkerJobUpdates(db *gorm.DB, jobUpdatesChan chan *transcoder.JobStatus) {
-	for jobStatus := range jobUpdatesChan {
-		job := jobStatus.Job
+func saveWorkerJobUpdates(db *gorm.DB, updates <-chan transcoder.JobEvent) {
+	for event := range updates {
+		job := event.Job
 		if job == nil {
 			continue
 		}
@@ -2,6 +2,7 @@ package controller
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -13,18 +14,16 @@ import (
 )
 
 type Controller struct {
-	mutex          *sync.Mutex
-	jobs           map[uuid.UUID]*transcoder.Job
-	jobChan        chan *transcoder.Job
-	jobUpdatesChan chan *transcoder.JobStatus
+	mutex *sync.Mutex
+	jobs  map[uuid.UUID]*transcoder.Job
+	pool  *transcoder.Pool
 }
 
-func NewController(jobChan chan *transcoder.Job, jobUpdatesChan chan *transcoder.JobStatus) *Controller {
+func NewController(pool *transcoder.Pool) *Controller {
 	controller := &Controller{
-		jobChan:        jobChan,
-		jobUpdatesChan: jobUpdatesChan,
-		mutex:          &sync.Mutex{},
-		jobs:           make(map[uuid.UUID]*transcoder.Job),
+		pool:  pool,
+		mutex: &sync.Mutex{},
+		jobs:  make(map[uuid.UUID]*transcoder.Job),
 	}
 	return controller
 }
@@ -51,8 +50,9 @@ func writeJSONResponse(w http.ResponseWriter, code int, body interface{}) {
 }
 
 type errResponse struct {
-	Code    int    `json:"code"`
-	Message string `json:"message"`
+	Code    int                     `json:"code"`
+	Message string                  `json:"message"`
+	Fields  []transcoder.FieldError `json:"fields,omitempty"`
 }
 
 func writeErrResponse(w http.ResponseWriter, code int, message string) {
@@ -68,11 +68,35 @@ func writeErrResponse(w http.ResponseWriter, code int, message string) {
 	w.Write(b)
 }
 
+// writeJobErrResponse - field-level errors for invalid params, otherwise a plain bad request
+func writeJobErrResponse(w http.ResponseWriter, err error) {
+	var validationErr *transcoder.ValidationError
+	if !errors.As(err, &validationErr) {
+		writeErrResponse(w, http.StatusBadRequest, fmt.Sprintf("creating job %v", err))
+		return
+	}
+	code := http.StatusUnprocessableEntity
+	writeJSONResponse(w, code, errResponse{Code: code, Message: "invalid params", Fields: validationErr.Fields})
+}
+
+// submitErrCode - 503 while the pool is shutting down so clients can retry elsewhere
+func submitErrCode(err error) int {
+	if errors.Is(err, transcoder.ErrPoolClosed) {
+		return http.StatusServiceUnavailable
+	}
+	if errors.Is(err, transcoder.ErrJobQueued) {
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
 func (c *Controller) sendToQueue(job *transcoder.Job) error {
+	if err := c.pool.Submit(job); err != nil {
+		return err
+	}
 	c.mutex.Lock()
 	c.jobs[job.ID] = job
 	c.mutex.Unlock()
-	c.jobChan <- job
 	go func() {
 		job.Wait()
 		log.Printf("%v err: %v", job.ID, job.Err())
@@ -0,0 +1,189 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/palmdalian/transcoder"
+	"github.com/palmdalian/transcoder/ffprobe"
+	"github.com/palmdalian/transcoder/transcodertest"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+const copyPresetID = "f12e777d-4666-484c-99b9-fd0ec24c9f3e"
+
+func newTestRouter() (*mux.Router, *Controller, *transcodertest.Executor) {
+	executor := transcodertest.NewExecutor(transcodertest.Progress)
+	executor.Script = func(cmd *transcoder.Command) transcodertest.Script {
+		if cmd.Path == ffprobe.Path {
+			return transcodertest.Probe(10 * time.Second)
+		}
+		return transcodertest.Progress
+	}
+	pool := transcoder.NewPool(1, nil)
+	pool.Executor = executor
+
+	c := NewController(pool)
+	r := mux.NewRouter()
+	r.HandleFunc("/preset-groups/{presetGroupID}/submit", c.SubmitPresetGroupJob)
+	r.HandleFunc("/presets/{presetID}/submit", c.SubmitPresetJob)
+	r.HandleFunc("/jobs/{jobID}", c.GetJob)
+	r.HandleFunc("/pool", c.GetPool)
+	r.HandleFunc("/pool/resize", c.ResizePool)
+	return r, c, executor
+}
+
+func TestSubmitPresetJob(t *testing.T) {
+	r, c, executor := newTestRouter()
+
+	w := httptest.NewRecorder()
+	body := `{"params": {"input": "in.mov", "output": "out"}}`
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/presets/"+copyPresetID+"/submit", strings.NewReader(body)))
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("code = %d, want %d: %s", w.Code, http.StatusAccepted, w.Body)
+	}
+
+	submitted := &transcoder.Job{}
+	if err := json.Unmarshal(w.Body.Bytes(), submitted); err != nil {
+		t.Fatal(err)
+	}
+	job, ok := c.getJob(submitted.ID)
+	if !ok {
+		t.Fatalf("job %v not found", submitted.ID)
+	}
+	job.Wait()
+	if job.Status != transcoder.JobStatusDone {
+		t.Errorf("Status = %q, want %q", job.Status, transcoder.JobStatusDone)
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/jobs/"+job.ID.String(), nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("GetJob code = %d, want %d", w.Code, http.StatusOK)
+	}
+	// The example presets probe their input first
+	if n := len(executor.Commands()); n != 2 {
+		t.Errorf("ran %d commands, want 2", n)
+	}
+}
+
+func TestSubmitPresetJobInvalid(t *testing.T) {
+	r, _, executor := newTestRouter()
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/presets/"+copyPresetID+"/submit", strings.NewReader(`{"params": {"input": "in.mov"}}`)))
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("code = %d, want %d: %s", w.Code, http.StatusUnprocessableEntity, w.Body)
+	}
+	resp := &errResponse{}
+	if err := json.Unmarshal(w.Body.Bytes(), resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Fields) != 1 || resp.Fields[0].Field != "output" {
+		t.Errorf("Fields = %+v, want output error", resp.Fields)
+	}
+	if n := len(executor.Commands()); n != 0 {
+		t.Errorf("ran %d commands, want 0", n)
+	}
+}
+
+func TestSubmitPresetGroupJobInvalid(t *testing.T) {
+	r, c, executor := newTestRouter()
+
+	// The second preset needs a param the first doesn't, so only it fails validation
+	scaled := &transcoder.Preset{
+		ID:     uuid.New(),
+		Path:   "ffmpeg",
+		Args:   []string{"-i", "{{input}}", "-vf", "scale={{width}}:-2", "{{output}}"},
+		Params: append([]transcoder.ParamSpec{{Name: "width", Type: transcoder.ParamTypeInt, Required: true}}, inputOutputParams...),
+	}
+	groupID := uuid.New()
+	presetGroups[groupID] = &transcoder.PresetGroup{Presets: []*transcoder.Preset{presets[uuid.MustParse(copyPresetID)], scaled, scaled}}
+	defer delete(presetGroups, groupID)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/preset-groups/"+groupID.String()+"/submit", strings.NewReader(`{"params": {"input": "in.mov"}}`)))
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("code = %d, want %d: %s", w.Code, http.StatusUnprocessableEntity, w.Body)
+	}
+	resp := &errResponse{}
+	if err := json.Unmarshal(w.Body.Bytes(), resp); err != nil {
+		t.Fatal(err)
+	}
+	fields := map[string]int{}
+	for _, field := range resp.Fields {
+		fields[field.Field]++
+	}
+	if len(resp.Fields) != 2 || fields["output"] != 1 || fields["width"] != 1 {
+		t.Errorf("Fields = %+v, want one output and one width error", resp.Fields)
+	}
+	if stats := c.pool.Stats(); stats.Queued != 0 || len(executor.Commands()) != 0 {
+		t.Errorf("queued %d jobs and ran %d commands, want none", stats.Queued, len(executor.Commands()))
+	}
+}
+
+func TestSubmitPresetJobDryRun(t *testing.T) {
+	r, _, executor := newTestRouter()
+
+	w := httptest.NewRecorder()
+	body := `{"params": {"input": "in.mov", "output": "out"}}`
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/presets/"+copyPresetID+"/submit?dryRun=true", strings.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("code = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	resp := &dryRunResponse{}
+	if err := json.Unmarshal(w.Body.Bytes(), resp); err != nil {
+		t.Fatal(err)
+	}
+	want := "ffmpeg -y -progress - -nostats -i in.mov -c copy out.mp4"
+	if got := resp.Command.String(); got != want {
+		t.Errorf("command = %q, want %q", got, want)
+	}
+	if n := len(executor.Commands()); n != 0 {
+		t.Errorf("ran %d commands, want 0", n)
+	}
+}
+
+func TestSubmitPresetJobNotFound(t *testing.T) {
+	r, _, _ := newTestRouter()
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/presets/00000000-0000-0000-0000-000000000000/submit", strings.NewReader(`{}`)))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("code = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestResizePool(t *testing.T) {
+	r, _, _ := newTestRouter()
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/pool/resize?size=3", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("code = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/pool", nil))
+	stats := transcoder.PoolStats{}
+	if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Size != 3 || len(stats.Workers) != 3 {
+		t.Errorf("Size = %d with %d workers, want 3", stats.Size, len(stats.Workers))
+	}
+
+	for _, size := range []string{"", "two", "-1"} {
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/pool/resize?size="+size, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("size %q code = %d, want %d", size, w.Code, http.StatusBadRequest)
+		}
+	}
+}
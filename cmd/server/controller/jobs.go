@@ -1,9 +1,12 @@
 package controller
 
 import (
+	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
 	"sort"
+	"strconv"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
@@ -22,14 +25,15 @@ func (c *Controller) GetJob(w http.ResponseWriter, r *http.Request) {
 		writeErrResponse(w, http.StatusNotFound, fmt.Sprintf("getting job %v", err))
 		return
 	}
-	writeJSONResponse(w, http.StatusOK, job)
+	// Workers write the job while it runs, marshal a copy
+	writeJSONResponse(w, http.StatusOK, job.Copy())
 }
 
 func (c *Controller) GetJobs(w http.ResponseWriter, r *http.Request) {
 	params := r.URL.Query()
 	states := params["status"]
 	if len(states) == 0 {
-		states = []string{transcoder.JobStatusSubmitted, transcoder.JobStatusInProgress}
+		states = []string{transcoder.JobStatusSubmitted, transcoder.JobStatusInProgress, transcoder.JobStatusRetrying}
 	}
 
 	jobs := c.getJobs(states)
@@ -50,7 +54,7 @@ func (c *Controller) JobInfo(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if job.Status != transcoder.JobStatusInProgress {
-		stat := &transcoder.JobStatus{Status: job.Status, Message: "Job is not running", Job: job}
+		stat := transcoder.NewJobStatus(job, "Job is not running")
 		writeJSONResponse(w, http.StatusBadRequest, stat)
 		return
 	}
@@ -58,6 +62,87 @@ func (c *Controller) JobInfo(w http.ResponseWriter, r *http.Request) {
 	writeJSONResponse(w, http.StatusOK, job.Info())
 }
 
+// JobLog - page through job output with ?offset=&limit=
+func (c *Controller) JobLog(w http.ResponseWriter, r *http.Request) {
+	jobID, err := uuid.Parse(mux.Vars(r)["jobID"])
+	if err != nil {
+		writeErrResponse(w, http.StatusBadRequest, fmt.Sprintf("bad jobID %v", err))
+		return
+	}
+
+	job, ok := c.getJob(jobID)
+	if !ok {
+		writeErrResponse(w, http.StatusNotFound, fmt.Sprintf("jobID %v", jobID))
+		return
+	}
+
+	params := r.URL.Query()
+	offset, limit := 0, 0
+	if o := params.Get("offset"); o != "" {
+		if offset, err = strconv.Atoi(o); err != nil {
+			writeErrResponse(w, http.StatusBadRequest, fmt.Sprintf("bad offset %v", err))
+			return
+		}
+	}
+	if l := params.Get("limit"); l != "" {
+		if limit, err = strconv.Atoi(l); err != nil {
+			writeErrResponse(w, http.StatusBadRequest, fmt.Sprintf("bad limit %v", err))
+			return
+		}
+	}
+
+	lines, err := job.Log(offset, limit)
+	if err != nil {
+		writeErrResponse(w, http.StatusInternalServerError, fmt.Sprintf("reading log %v", err))
+		return
+	}
+	writeJSONResponse(w, http.StatusOK, lines)
+}
+
+// JobEvents - stream job progress and log lines as server-sent events until the job is done
+func (c *Controller) JobEvents(w http.ResponseWriter, r *http.Request) {
+	jobID, err := uuid.Parse(mux.Vars(r)["jobID"])
+	if err != nil {
+		writeErrResponse(w, http.StatusBadRequest, fmt.Sprintf("bad jobID %v", err))
+		return
+	}
+
+	job, ok := c.getJob(jobID)
+	if !ok {
+		writeErrResponse(w, http.StatusNotFound, fmt.Sprintf("jobID %v", jobID))
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeErrResponse(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+
+	events, unsubscribe := job.Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			b, err := json.Marshal(event)
+			if err != nil {
+				log.Printf("Err marshaling event %v", err)
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, b)
+			flusher.Flush()
+		}
+	}
+}
+
 func (c *Controller) JobKill(w http.ResponseWriter, r *http.Request) {
 	jobID, err := uuid.Parse(mux.Vars(r)["jobID"])
 	if err != nil {
@@ -71,8 +156,8 @@ func (c *Controller) JobKill(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if job.Status != transcoder.JobStatusInProgress {
-		stat := &transcoder.JobStatus{Status: job.Status, Message: "Job is not running", Job: job}
+	if job.Status != transcoder.JobStatusInProgress && job.Status != transcoder.JobStatusRetrying {
+		stat := transcoder.NewJobStatus(job, "Job is not running")
 		writeJSONResponse(w, http.StatusBadRequest, stat)
 		return
 	}
@@ -92,8 +177,10 @@ func (c *Controller) JobResubmit(w http.ResponseWriter, r *http.Request) {
 		writeErrResponse(w, http.StatusNotFound, fmt.Sprintf("jobID %v", jobID))
 		return
 	}
-	if job.Status == transcoder.JobStatusInProgress {
-		stat := &transcoder.JobStatus{Status: job.Status, Message: "Job is not running", Job: job}
+	// Queued and retrying jobs are still in the pool, resetting them would queue the same job twice
+	switch job.Status {
+	case transcoder.JobStatusSubmitted, transcoder.JobStatusInProgress, transcoder.JobStatusRetrying:
+		stat := transcoder.NewJobStatus(job, "Job has not finished")
 		writeJSONResponse(w, http.StatusBadRequest, stat)
 		return
 	}
@@ -106,10 +193,10 @@ func (c *Controller) JobResubmit(w http.ResponseWriter, r *http.Request) {
 	job.Preset = preset
 
 	if err = c.sendToQueue(job); err != nil {
-		writeErrResponse(w, http.StatusInternalServerError, fmt.Sprintf("submitting to queue %v", err))
+		writeErrResponse(w, submitErrCode(err), fmt.Sprintf("submitting to queue %v", err))
 		return
 	}
-	writeJSONResponse(w, http.StatusAccepted, job)
+	writeJSONResponse(w, http.StatusAccepted, job.Copy())
 }
 
 func (c *Controller) getJob(jobID uuid.UUID) (*transcoder.Job, bool) {
@@ -119,6 +206,7 @@ func (c *Controller) getJob(jobID uuid.UUID) (*transcoder.Job, bool) {
 	return job, ok
 }
 
+// getJobs - copies of the jobs in states, safe to marshal while workers run them
 func (c *Controller) getJobs(states []string) []*transcoder.Job {
 	stateMap := make(map[string]bool, len(states))
 	for _, s := range states {
@@ -130,10 +218,11 @@ func (c *Controller) getJobs(states []string) []*transcoder.Job {
 
 	jobs := make([]*transcoder.Job, 0, len(c.jobs))
 	for _, job := range c.jobs {
-		if _, ok := stateMap[job.Status]; !ok {
+		copied := job.Copy()
+		if _, ok := stateMap[copied.Status]; !ok {
 			continue
 		}
-		jobs = append(jobs, job)
+		jobs = append(jobs, copied)
 	}
 	sort.Slice(jobs, func(i, j int) bool { return jobs[i].CreatedAt.Before(jobs[j].CreatedAt) })
 	return jobs
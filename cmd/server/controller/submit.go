@@ -2,6 +2,7 @@ package controller
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 
@@ -11,7 +12,20 @@ import (
 )
 
 type JobSubmission struct {
-	Params map[string]string `json:"params"`
+	Params   map[string]string `json:"params"`
+	Priority int               `json:"priority,omitempty"` // higher runs first
+}
+
+// dryRunResponse - job that would have been queued and the command it would run
+type dryRunResponse struct {
+	Job     *transcoder.Job     `json:"job"`
+	Command *transcoder.Command `json:"command"`
+}
+
+// Params shared by the example presets
+var inputOutputParams = []transcoder.ParamSpec{
+	{Name: "input", Type: transcoder.ParamTypePath, Required: true},
+	{Name: "output", Type: transcoder.ParamTypePath, Required: true},
 }
 
 // Realistically, these should be saved in the DB
@@ -21,24 +35,33 @@ var presets = map[uuid.UUID]*transcoder.Preset{
 		Description: "Convert using ffmpeg defaults",
 		Path:        "ffmpeg",
 		Args:        []string{"-y", "-progress", "-", "-nostats", "-i", "{{input}}", "{{output}}"},
+		Params:      inputOutputParams,
+		ProbeInput:  "input",
+		Cost:        &transcoder.Cost{Slots: 2},
 	},
 	uuid.MustParse("f12e777d-4666-484c-99b9-fd0ec24c9f3e"): {
 		ID:          uuid.MustParse("f12e777d-4666-484c-99b9-fd0ec24c9f3e"),
 		Description: "Stream copy to mp4",
 		Path:        "ffmpeg",
 		Args:        []string{"-y", "-progress", "-", "-nostats", "-i", "{{input}}", "-c", "copy", "{{output}}.mp4"},
+		Params:      inputOutputParams,
+		ProbeInput:  "input",
 	},
 	uuid.MustParse("8826501e-bfa3-4743-b4d1-305dd1a40c72"): {
 		ID:          uuid.MustParse("8826501e-bfa3-4743-b4d1-305dd1a40c72"),
 		Description: "Audio only copy",
 		Path:        "ffmpeg",
 		Args:        []string{"-y", "-progress", "-", "-nostats", "-i", "{{input}}", "-c:a", "copy", "-vn", "{{output}}"},
+		Params:      inputOutputParams,
+		ProbeInput:  "input",
 	},
 	uuid.MustParse("2f7b5825-4ff9-4407-bf6e-20b0d2125d01"): {
 		ID:          uuid.MustParse("2f7b5825-4ff9-4407-bf6e-20b0d2125d01"),
 		Description: "Video only copy",
 		Path:        "ffmpeg",
 		Args:        []string{"-y", "-progress", "-", "-nostats", "-i", "{{input}}", "-c:v", "copy", "-an", "{{output}}"},
+		Params:      inputOutputParams,
+		ProbeInput:  "input",
 	},
 }
 
@@ -71,12 +94,28 @@ func (c *Controller) SubmitPresetJob(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	job := transcoder.NewJob(preset, submission.Params)
+	job, err := transcoder.NewJob(preset, submission.Params)
+	if err != nil {
+		writeJobErrResponse(w, err)
+		return
+	}
+	job.Priority = submission.Priority
+
+	if r.URL.Query().Get("dryRun") == "true" {
+		command, err := job.Command()
+		if err != nil {
+			writeJobErrResponse(w, err)
+			return
+		}
+		writeJSONResponse(w, http.StatusOK, dryRunResponse{Job: job, Command: command})
+		return
+	}
 	if err = c.sendToQueue(job); err != nil {
-		writeErrResponse(w, http.StatusInternalServerError, fmt.Sprintf("submitting to queue %v", err))
+		writeErrResponse(w, submitErrCode(err), fmt.Sprintf("submitting to queue %v", err))
 		return
 	}
-	writeJSONResponse(w, http.StatusAccepted, job)
+	// A worker may already be running the job, marshal a copy
+	writeJSONResponse(w, http.StatusAccepted, job.Copy())
 }
 
 func (c *Controller) SubmitPresetGroupJob(w http.ResponseWriter, r *http.Request) {
@@ -100,14 +139,41 @@ func (c *Controller) SubmitPresetGroupJob(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	// Validate every preset before queueing anything so a bad group doesn't leave some jobs running
 	jobs := make([]*transcoder.Job, len(presetGroup.Presets))
+	invalid := &transcoder.ValidationError{}
+	seen := map[transcoder.FieldError]bool{}
 	for i, preset := range presetGroup.Presets {
-		job := transcoder.NewJob(preset, submission.Params)
+		job, err := transcoder.NewJob(preset, submission.Params)
+		var validationErr *transcoder.ValidationError
+		switch {
+		case errors.As(err, &validationErr):
+			for _, field := range validationErr.Fields {
+				if !seen[field] {
+					seen[field] = true
+					invalid.Fields = append(invalid.Fields, field)
+				}
+			}
+			continue
+		case err != nil:
+			writeJobErrResponse(w, err)
+			return
+		}
+		job.Priority = submission.Priority
 		jobs[i] = job
+	}
+	if len(invalid.Fields) > 0 {
+		writeJobErrResponse(w, invalid)
+		return
+	}
+
+	submitted := make([]*transcoder.Job, len(jobs))
+	for i, job := range jobs {
 		if err := c.sendToQueue(job); err != nil {
-			writeErrResponse(w, http.StatusInternalServerError, fmt.Sprintf("submitting to queue %v", err))
+			writeErrResponse(w, submitErrCode(err), fmt.Sprintf("submitting to queue %v", err))
 			return
 		}
+		submitted[i] = job.Copy()
 	}
-	writeJSONResponse(w, http.StatusAccepted, jobs)
+	writeJSONResponse(w, http.StatusAccepted, submitted)
 }
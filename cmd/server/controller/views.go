@@ -41,7 +41,7 @@ func (c *Controller) GetJobsView(w http.ResponseWriter, r *http.Request) {
 	params := r.URL.Query()
 	states := params["status"]
 	if len(states) == 0 {
-		states = []string{transcoder.JobStatusSubmitted, transcoder.JobStatusInProgress}
+		states = []string{transcoder.JobStatusSubmitted, transcoder.JobStatusInProgress, transcoder.JobStatusRetrying}
 	}
 
 	jobs := c.getJobs(states)
@@ -67,6 +67,7 @@ type templateFullJob struct {
 	Params        transcoder.JobParams
 	TotalDuration float64
 	CurrentTime   float64
+	Progress      transcoder.Progress
 	ErrOutput     []string
 	Output        []string
 }
@@ -74,7 +75,8 @@ type templateFullJob struct {
 var jobTemplate = `
 	<h1>ID: {{.Job.ID}}</h1><p>
 	Status: {{.Job.Status}}<br>
-	Percent complete: {{percent .CurrentTime .TotalDuration }} % <br>
+	Percent complete: {{.Progress.Percent}} % <br>
+	Speed: {{.Progress.Speed}}x, ETA: {{.Progress.ETA}}s, Frame: {{.Progress.Frame}}, FPS: {{.Progress.FPS}}<br>
 	Created: {{.Job.CreatedAt}}<br>
 	Preset: {{.Job.Preset}}<br>
 	Params: {{.Job.Params}}<br>
@@ -127,8 +129,11 @@ var jobsTemplate = `
 	<option value=""></option>
   <option value="inProgress">In Progress</option>
   <option value="submitted">Submitted</option>
+  <option value="retrying">Retrying</option>
   <option value="done">Done</option>
   <option value="failed">Failed</option>
+  <option value="timedOut">Timed Out</option>
+  <option value="canceled">Canceled</option>
 </select>
 
 {{- range . }}
@@ -1,9 +1,14 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/palmdalian/transcoder"
 	"github.com/palmdalian/transcoder/cmd/server/controller"
@@ -12,20 +17,18 @@ import (
 )
 
 const (
-	WorkerNum = 2
-	Port      = 3210
-	QueueName = "jobs"
+	WorkerNum       = 2 // Initial size, change with /pool/resize
+	CapacitySlots   = 2 // CPU slots shared by running jobs, encodes take 2 and stream copies 1
+	Port            = 3210
+	QueueName       = "jobs"
+	ShutdownTimeout = 30 * time.Second // Running jobs are stopped in time to exit before this
 )
 
 func main() {
-	jobQueue := make(chan *transcoder.Job, 100)
-	jobUpdatesChan := make(chan *transcoder.JobStatus, 100)
-	for i := 0; i < WorkerNum; i++ {
-		worker := transcoder.NewWorker(jobQueue, jobUpdatesChan)
-		worker.Name = fmt.Sprintf("Worker%d", i)
-	}
+	pool := transcoder.NewPool(WorkerNum, nil)
+	pool.SetCapacity(transcoder.Cost{Slots: CapacitySlots})
 
-	controller := controller.NewController(jobQueue, jobUpdatesChan)
+	controller := controller.NewController(pool)
 	r := mux.NewRouter()
 	r.HandleFunc("/preset-groups/{presetGroupID}/submit", controller.SubmitPresetGroupJob)
 	r.HandleFunc("/presets/{presetID}/submit", controller.SubmitPresetJob)
@@ -35,9 +38,37 @@ func main() {
 	r.HandleFunc("/jobs/{jobID}/info.html", controller.GetJobView)
 	r.HandleFunc("/jobs/{jobID}/resubmit", controller.JobResubmit)
 	r.HandleFunc("/jobs/{jobID}/info", controller.JobInfo)
+	r.HandleFunc("/jobs/{jobID}/events", controller.JobEvents)
+	r.HandleFunc("/jobs/{jobID}/log", controller.JobLog)
 	r.HandleFunc("/jobs/{jobID}/kill", controller.JobKill)
+	r.HandleFunc("/pool", controller.GetPool)
+	r.HandleFunc("/pool/resize", controller.ResizePool)
 	http.Handle("/", r)
 
-	log.Printf("Listening on :%d...\n", Port)
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", Port), http.DefaultServeMux))
+	server := &http.Server{Addr: fmt.Sprintf(":%d", Port), Handler: http.DefaultServeMux}
+	go func() {
+		log.Printf("Listening on :%d...\n", Port)
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	sig := <-shutdownSignal()
+	log.Printf("Got %v, draining jobs...", sig)
+	ctx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+	// Drain first so event streams of running jobs can finish
+	if err := pool.ShutdownWithin(ctx); err != nil {
+		log.Printf("Stopped running jobs: %v", err)
+	}
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Err shutting down server: %v", err)
+	}
+}
+
+// shutdownSignal - receives SIGTERM or interrupt
+func shutdownSignal() <-chan os.Signal {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	return signals
 }
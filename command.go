@@ -0,0 +1,185 @@
+package transcoder
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Command - resolved executable, args and environment for a job
+type Command struct {
+	Path string   `json:"path"`
+	Args []string `json:"args"`
+	Env  []string `json:"env,omitempty"` // added to the inherited environment
+	Dir  string   `json:"dir,omitempty"`
+
+	StdinPath string `json:"stdinPath,omitempty"`
+	StdinData string `json:"stdinData,omitempty"`
+
+	// Pre and Post - rendered preset hooks, empty for hooks themselves
+	Pre  []*HookCommand `json:"pre,omitempty"`
+	Post []*HookCommand `json:"post,omitempty"`
+
+	// Verify - rendered Preset.Verify output path
+	Verify string `json:"verify,omitempty"`
+
+	// Interactive - keep stdin open so the process can be sent commands, see StopQuit
+	Interactive bool `json:"-"`
+}
+
+// StdinSource - what a preset pipes into the process
+// Path is templated like Args. Param sends the value of that job param
+type StdinSource struct {
+	Path  string `json:"path,omitempty"`
+	Param string `json:"param,omitempty"`
+}
+
+// Render - resolve the preset for params without running anything
+func (preset *Preset) Render(params JobParams) (*Command, error) {
+	// Render everything at once so all missing params are reported together
+	templates := make([]string, 0, len(preset.Args)+len(preset.Env)+2)
+	templates = append(templates, preset.Args...)
+	templates = append(templates, preset.Env...)
+	templates = append(templates, preset.Dir, "")
+	if preset.Stdin != nil {
+		templates[len(templates)-1] = preset.Stdin.Path
+	}
+	verifyAt := len(templates)
+	if preset.Verify != nil {
+		templates = append(templates, preset.verifyOutput())
+	}
+	hooks := append(append([]Hook{}, preset.Pre...), preset.Post...)
+	hookAt := make([]int, len(hooks))
+	for i := range hooks {
+		hookAt[i] = len(templates)
+		templates = append(templates, hooks[i].templates()...)
+	}
+	rendered, err := RenderAll(templates, params)
+	if preset.Stdin != nil && preset.Stdin.Param != "" {
+		if _, ok := params[preset.Stdin.Param]; !ok {
+			missing, _ := err.(*MissingParamError)
+			if missing == nil {
+				missing = &MissingParamError{}
+			}
+			missing.Names = append(missing.Names, preset.Stdin.Param)
+			err = missing
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if preset.Verify != nil && preset.verifyOutput() == "" {
+		return nil, ErrNoVerifyOutput
+	}
+	if preset.Verify != nil && preset.Verify.DurationTolerance > 0 && preset.ProbeInput == "" {
+		return nil, ErrNoProbeInput
+	}
+
+	nArgs, nEnv := len(preset.Args), len(preset.Env)
+	command := &Command{
+		Path:      preset.Path,
+		Args:      rendered[:nArgs],
+		Dir:       rendered[nArgs+nEnv],
+		StdinPath: rendered[nArgs+nEnv+1],
+	}
+	if nEnv > 0 {
+		command.Env = rendered[nArgs : nArgs+nEnv]
+	}
+	if preset.Stdin != nil && preset.Stdin.Param != "" {
+		command.StdinData = params[preset.Stdin.Param]
+	}
+	if preset.Verify != nil {
+		command.Verify = rendered[verifyAt]
+	}
+	for i := range hooks {
+		hook := hooks[i].newHookCommand(rendered[hookAt[i]:])
+		if i < len(preset.Pre) {
+			command.Pre = append(command.Pre, hook)
+		} else {
+			command.Post = append(command.Post, hook)
+		}
+	}
+	return command, nil
+}
+
+// Command - the exact command Run will execute
+func (job *Job) Command() (*Command, error) {
+	return job.Preset.Render(job.renderParams())
+}
+
+// SetStdin - pipe r into the process instead of the preset StdinSource
+// r is read once, retried attempts get whatever is left
+func (job *Job) SetStdin(r io.Reader) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	job.stdinReader = r
+}
+
+// openStdin - reader for the process stdin, nil if nothing is piped in
+// The returned closer is nil unless a file was opened
+func (job *Job) openStdin(command *Command) (io.Reader, io.Closer, error) {
+	job.mu.RLock()
+	r := job.stdinReader
+	job.mu.RUnlock()
+	switch {
+	case r != nil:
+		return r, nil, nil
+	case command.StdinPath != "":
+		f, err := os.Open(command.StdinPath)
+		if err != nil {
+			return nil, nil, fmt.Errorf("opening stdin %w", err)
+		}
+		return f, f, nil
+	case command.StdinData != "":
+		return strings.NewReader(command.StdinData), nil, nil
+	}
+	return nil, nil, nil
+}
+
+// environ - inherited environment with the command additions
+func (cmd *Command) environ() []string {
+	if len(cmd.Env) == 0 {
+		return nil
+	}
+	return append(os.Environ(), cmd.Env...)
+}
+
+// safeShellReg - args that can be printed without quoting
+var safeShellReg = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// String - shell-quoted command line for logging and copy-pasting
+func (cmd *Command) String() string {
+	parts := make([]string, 0, len(cmd.Args)+len(cmd.Env)+4)
+	if cmd.Dir != "" {
+		parts = append(parts, "cd", shellQuote(cmd.Dir), "&&")
+	}
+	for _, env := range cmd.Env {
+		parts = append(parts, shellQuoteEnv(env))
+	}
+	parts = append(parts, shellQuote(cmd.Path))
+	for _, arg := range cmd.Args {
+		parts = append(parts, shellQuote(arg))
+	}
+	if cmd.StdinPath != "" {
+		parts = append(parts, "<", shellQuote(cmd.StdinPath))
+	}
+	return strings.Join(parts, " ")
+}
+
+func shellQuote(s string) string {
+	if safeShellReg.MatchString(s) {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// shellQuoteEnv - quote only the value of a KEY=value entry so the shell still reads it as an assignment
+func shellQuoteEnv(env string) string {
+	i := strings.IndexByte(env, '=')
+	if i < 0 || !identifierReg.MatchString(env[:i]) {
+		return shellQuote(env)
+	}
+	return env[:i+1] + shellQuote(env[i+1:])
+}
@@ -0,0 +1,45 @@
+package transcoder
+
+// Cost - resources a job is expected to hold while it runs
+type Cost struct {
+	Slots  int   `json:"slots,omitempty"`  // CPU slots, a 4K encode might take all of them and a stream copy one
+	Memory int64 `json:"memory,omitempty"` // estimated peak bytes
+}
+
+// DefaultCost - used for presets without a Cost
+var DefaultCost = Cost{Slots: 1}
+
+func (cost Cost) add(other Cost) Cost {
+	return Cost{Slots: cost.Slots + other.Slots, Memory: cost.Memory + other.Memory}
+}
+
+func (cost Cost) sub(other Cost) Cost {
+	return Cost{Slots: cost.Slots - other.Slots, Memory: cost.Memory - other.Memory}
+}
+
+func (cost Cost) isZero() bool {
+	return cost.Slots == 0 && cost.Memory == 0
+}
+
+// admits - whether a job costing cost can start while used is already running
+// Zero capacity fields are unlimited. A job larger than capacity still runs once nothing else is
+func (capacity Cost) admits(used, cost Cost) bool {
+	if used.isZero() {
+		return true
+	}
+	if capacity.Slots > 0 && used.Slots+cost.Slots > capacity.Slots {
+		return false
+	}
+	if capacity.Memory > 0 && used.Memory+cost.Memory > capacity.Memory {
+		return false
+	}
+	return true
+}
+
+// cost - what the job holds in a Pool budget
+func (job *Job) cost() Cost {
+	if job.Preset == nil || job.Preset.Cost == nil {
+		return DefaultCost
+	}
+	return *job.Preset.Cost
+}
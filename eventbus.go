@@ -0,0 +1,154 @@
+package transcoder
+
+import (
+	"sync"
+)
+
+// DefaultEventBuffer - events buffered per Job.Subscribe subscriber, and per EventBus subscriber when SubscribeOptions.Buffer is zero
+const DefaultEventBuffer = 100
+
+// OverflowPolicy - what an EventBus does when a subscriber's buffer is full
+// OverflowBlock subscribers must not call into the Pool publishing to them while handling an event
+type OverflowPolicy int
+
+const (
+	OverflowDropNewest OverflowPolicy = iota // drop the new event
+	OverflowDropOldest                       // drop the oldest buffered event to make room
+	OverflowBlock                            // wait for the subscriber, stalling whoever publishes
+)
+
+// StatusEventTypes - job lifecycle events, everything except progress and log
+var StatusEventTypes = []string{
+	JobEventQueued,
+	JobEventStarted,
+	JobEventRetried,
+	JobEventSucceeded,
+	JobEventFailed,
+	JobEventKilled,
+}
+
+// SubscribeOptions - buffering and filtering of an EventBus subscription
+type SubscribeOptions struct {
+	Buffer   int            // events buffered, zero uses DefaultEventBuffer
+	Overflow OverflowPolicy // defaults to OverflowDropNewest
+	Types    []string       // event types to receive, empty receives all
+}
+
+// EventBus - fans out JobEvents published by pools and workers to any number of subscribers
+// A nil *EventBus drops every event
+type EventBus struct {
+	mu          sync.RWMutex
+	subscribers map[int]*busSubscriber
+	next        int
+}
+
+type busSubscriber struct {
+	mu       sync.Mutex
+	events   chan JobEvent
+	closed   chan struct{} // closed before events so blocked publishers give up
+	once     sync.Once
+	overflow OverflowPolicy
+	types    map[string]bool
+}
+
+// NewEventBus create a bus without subscribers
+func NewEventBus() *EventBus {
+	return &EventBus{subscribers: make(map[int]*busSubscriber)}
+}
+
+// Subscribe - receive published events until the returned func is called, which closes the channel
+func (bus *EventBus) Subscribe(opts SubscribeOptions) (<-chan JobEvent, func()) {
+	buffer := opts.Buffer
+	if buffer <= 0 {
+		buffer = DefaultEventBuffer
+	}
+	sub := &busSubscriber{
+		events:   make(chan JobEvent, buffer),
+		closed:   make(chan struct{}),
+		overflow: opts.Overflow,
+	}
+	if len(opts.Types) > 0 {
+		sub.types = make(map[string]bool, len(opts.Types))
+		for _, t := range opts.Types {
+			sub.types[t] = true
+		}
+	}
+
+	bus.mu.Lock()
+	id := bus.next
+	bus.next++
+	bus.subscribers[id] = sub
+	bus.mu.Unlock()
+
+	unsubscribe := func() {
+		bus.mu.Lock()
+		delete(bus.subscribers, id)
+		bus.mu.Unlock()
+		sub.close()
+	}
+	return sub.events, unsubscribe
+}
+
+// Publish - send event to every subscriber according to its OverflowPolicy
+func (bus *EventBus) Publish(event JobEvent) {
+	if bus == nil {
+		return
+	}
+	bus.mu.RLock()
+	subs := make([]*busSubscriber, 0, len(bus.subscribers))
+	for _, sub := range bus.subscribers {
+		subs = append(subs, sub)
+	}
+	bus.mu.RUnlock()
+
+	for _, sub := range subs {
+		sub.send(event)
+	}
+}
+
+func (sub *busSubscriber) send(event JobEvent) {
+	if sub.types != nil && !sub.types[event.Type] {
+		return
+	}
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	select {
+	case <-sub.closed:
+		return
+	default:
+	}
+
+	switch sub.overflow {
+	case OverflowBlock:
+		select {
+		case sub.events <- event:
+		case <-sub.closed:
+		}
+	case OverflowDropOldest:
+		for {
+			select {
+			case sub.events <- event:
+				return
+			default:
+			}
+			select {
+			case <-sub.events:
+			default:
+			}
+		}
+	default:
+		select {
+		case sub.events <- event:
+		default:
+		}
+	}
+}
+
+func (sub *busSubscriber) close() {
+	sub.once.Do(func() {
+		close(sub.closed)
+		sub.mu.Lock()
+		close(sub.events)
+		sub.mu.Unlock()
+	})
+}
@@ -0,0 +1,89 @@
+package transcoder_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/palmdalian/transcoder"
+	"github.com/palmdalian/transcoder/transcodertest"
+)
+
+func TestEventBusOverflow(t *testing.T) {
+	tests := []struct {
+		overflow transcoder.OverflowPolicy
+		want     string
+	}{
+		{transcoder.OverflowDropNewest, "first"},
+		{transcoder.OverflowDropOldest, "third"},
+	}
+	for _, tt := range tests {
+		bus := transcoder.NewEventBus()
+		events, unsubscribe := bus.Subscribe(transcoder.SubscribeOptions{Buffer: 1, Overflow: tt.overflow})
+		for _, line := range []string{"first", "second", "third"} {
+			bus.Publish(transcoder.JobEvent{Type: transcoder.JobEventLog, Line: line})
+		}
+		unsubscribe()
+
+		var lines []string
+		for event := range events {
+			lines = append(lines, event.Line)
+		}
+		if len(lines) != 1 || lines[0] != tt.want {
+			t.Errorf("overflow %d received %v, want [%s]", tt.overflow, lines, tt.want)
+		}
+	}
+}
+
+func TestEventBusBlock(t *testing.T) {
+	bus := transcoder.NewEventBus()
+	_, unsubscribe := bus.Subscribe(transcoder.SubscribeOptions{Buffer: 1, Overflow: transcoder.OverflowBlock})
+
+	published := make(chan struct{})
+	go func() {
+		bus.Publish(transcoder.JobEvent{Type: transcoder.JobEventLog})
+		bus.Publish(transcoder.JobEvent{Type: transcoder.JobEventLog})
+		close(published)
+	}()
+	select {
+	case <-published:
+		t.Fatal("Publish returned with a full buffer")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	// Unsubscribing releases the blocked publisher
+	unsubscribe()
+	select {
+	case <-published:
+	case <-time.After(time.Second):
+		t.Fatal("Publish still blocked after unsubscribe")
+	}
+}
+
+func TestEventBusFanOut(t *testing.T) {
+	bus := transcoder.NewEventBus()
+	all, unsubscribeAll := bus.Subscribe(transcoder.SubscribeOptions{Buffer: 1000})
+	defer unsubscribeAll()
+	statuses, unsubscribeStatuses := bus.Subscribe(transcoder.SubscribeOptions{Types: transcoder.StatusEventTypes})
+	defer unsubscribeStatuses()
+
+	pool := transcoder.NewPool(1, bus)
+	pool.Executor = transcodertest.NewExecutor(transcodertest.Progress)
+	job := transcodertest.NewJob(nil)
+	if err := pool.Submit(job); err != nil {
+		t.Fatal(err)
+	}
+	job.Wait()
+
+	types := readEventTypes(statuses)
+	want := []string{transcoder.JobEventQueued, transcoder.JobEventStarted, transcoder.JobEventSucceeded}
+	if !equal(types, want) {
+		t.Errorf("status events = %v, want %v", types, want)
+	}
+
+	types = readEventTypes(all)
+	for _, eventType := range []string{transcoder.JobEventProgress, transcoder.JobEventLog, transcoder.JobEventSucceeded} {
+		if count(types, eventType) == 0 {
+			t.Errorf("no %s events in %v", eventType, types)
+		}
+	}
+}
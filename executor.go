@@ -0,0 +1,88 @@
+package transcoder
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/exec"
+)
+
+// Executor - starts the commands jobs run
+// Implementations can run commands locally, in containers, over SSH, or fake them in tests
+type Executor interface {
+	// Start - begin running cmd, piping stdin into it when not nil
+	// ctx only bounds starting the process, Job stops running processes with Process.Signal
+	Start(ctx context.Context, cmd *Command, stdin io.Reader) (Process, error)
+}
+
+// Process - a command started by an Executor
+type Process interface {
+	Stdout() io.Reader
+	Stderr() io.Reader
+	// Stdin - open pipe for interactive commands such as ffmpeg's "q"
+	// nil unless the Command is Interactive and no stdin reader was given
+	Stdin() io.WriteCloser
+	// Signal - send sig to the process and anything it started
+	Signal(sig os.Signal) error
+	// Wait - block until the process exits. Stdout and Stderr must be read to EOF first
+	// Returns the exit status and any resource usage the executor can measure
+	Wait() (ExitInfo, error)
+}
+
+// DefaultExecutor - used when neither the Job nor its Worker set an Executor
+var DefaultExecutor Executor = LocalExecutor{}
+
+// LocalExecutor - run commands on this machine with os/exec
+// Each command gets its own process group so signals reach children of wrapper scripts
+type LocalExecutor struct{}
+
+type localProcess struct {
+	cmd    *exec.Cmd
+	stdout io.Reader
+	stderr io.Reader
+	stdin  io.WriteCloser
+}
+
+func (LocalExecutor) Start(ctx context.Context, command *Command, stdin io.Reader) (Process, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(command.Path, command.Args...)
+	cmd.Env = command.environ()
+	cmd.Dir = command.Dir
+	setProcessGroup(cmd)
+
+	process := &localProcess{cmd: cmd}
+	var err error
+	if process.stdout, err = cmd.StdoutPipe(); err != nil {
+		return nil, err
+	}
+	if process.stderr, err = cmd.StderrPipe(); err != nil {
+		return nil, err
+	}
+	if stdin != nil {
+		cmd.Stdin = stdin
+	} else if command.Interactive {
+		if process.stdin, err = cmd.StdinPipe(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = cmd.Start(); err != nil {
+		return nil, err
+	}
+	return process, nil
+}
+
+func (p *localProcess) Stdout() io.Reader     { return p.stdout }
+func (p *localProcess) Stderr() io.Reader     { return p.stderr }
+func (p *localProcess) Stdin() io.WriteCloser { return p.stdin }
+
+func (p *localProcess) Signal(sig os.Signal) error {
+	return signalProcessGroup(p.cmd.Process, sig)
+}
+
+func (p *localProcess) Wait() (ExitInfo, error) {
+	err := p.cmd.Wait()
+	return newExitInfo(p.cmd.ProcessState), err
+}
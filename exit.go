@@ -0,0 +1,29 @@
+package transcoder
+
+import (
+	"os"
+	"time"
+)
+
+// ExitInfo - how the process ended and the resources it used
+type ExitInfo struct {
+	ExitCode   int           `json:"exitCode"`         // -1 if terminated by a signal
+	Signal     string        `json:"signal,omitempty"` // terminating signal
+	WallTime   time.Duration `json:"wallTime"`
+	UserTime   time.Duration `json:"userTime"`
+	SystemTime time.Duration `json:"systemTime"`
+	MaxRSS     int64         `json:"maxRss"` // bytes
+}
+
+// newExitInfo - collect exit status and resource usage from a finished local process
+func newExitInfo(state *os.ProcessState) ExitInfo {
+	exit := ExitInfo{ExitCode: -1}
+	if state == nil {
+		return exit
+	}
+	exit.ExitCode = state.ExitCode()
+	exit.UserTime = state.UserTime()
+	exit.SystemTime = state.SystemTime()
+	fillSysExitInfo(&exit, state)
+	return exit
+}
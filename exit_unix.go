@@ -0,0 +1,24 @@
+//go:build !windows
+// +build !windows
+
+package transcoder
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+)
+
+// fillSysExitInfo - terminating signal and max RSS from the unix wait status and rusage
+func fillSysExitInfo(exit *ExitInfo, state *os.ProcessState) {
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		exit.Signal = status.Signal().String()
+	}
+	if rusage, ok := state.SysUsage().(*syscall.Rusage); ok && rusage != nil {
+		exit.MaxRSS = int64(rusage.Maxrss)
+		// Linux reports kilobytes, darwin reports bytes
+		if runtime.GOOS != "darwin" {
+			exit.MaxRSS *= 1024
+		}
+	}
+}
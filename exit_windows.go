@@ -0,0 +1,9 @@
+//go:build windows
+// +build windows
+
+package transcoder
+
+import "os"
+
+// fillSysExitInfo - signals and max RSS are not available
+func fillSysExitInfo(exit *ExitInfo, state *os.ProcessState) {}
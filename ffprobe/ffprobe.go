@@ -0,0 +1,218 @@
+// Package ffprobe inspects media files with ffprobe
+package ffprobe
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Path - ffprobe executable
+var Path = "ffprobe"
+
+// Stream types
+const (
+	StreamVideo    = "video"
+	StreamAudio    = "audio"
+	StreamSubtitle = "subtitle"
+	StreamData     = "data"
+)
+
+// Result - what ffprobe found in an input
+type Result struct {
+	Format  Format   `json:"format"`
+	Streams []Stream `json:"streams"`
+}
+
+// Format - container information
+type Format struct {
+	Filename   string  `json:"filename"`
+	FormatName string  `json:"formatName"` // comma separated, e.g. "mov,mp4,m4a,3gp,3g2,mj2"
+	Duration   float64 `json:"duration"`   // seconds
+	Size       int64   `json:"size"`       // bytes
+	BitRate    int64   `json:"bitRate"`    // bits per second
+}
+
+// Stream - a single video, audio, subtitle or data stream
+type Stream struct {
+	Index         int     `json:"index"`
+	CodecType     string  `json:"codecType"` // StreamVideo, StreamAudio, ...
+	CodecName     string  `json:"codecName"`
+	Profile       string  `json:"profile,omitempty"`
+	Duration      float64 `json:"duration,omitempty"` // seconds
+	BitRate       int64   `json:"bitRate,omitempty"`
+	Width         int     `json:"width,omitempty"`
+	Height        int     `json:"height,omitempty"`
+	PixFmt        string  `json:"pixFmt,omitempty"`
+	FrameRate     float64 `json:"frameRate,omitempty"`
+	SampleRate    int     `json:"sampleRate,omitempty"`
+	Channels      int     `json:"channels,omitempty"`
+	ChannelLayout string  `json:"channelLayout,omitempty"` // e.g. "stereo", "5.1(side)"
+}
+
+// Args - ffprobe arguments printing input as JSON for Parse
+func Args(input string) []string {
+	return []string{"-v", "error", "-print_format", "json", "-show_format", "-show_streams", input}
+}
+
+// Probe - run ffprobe on input
+func Probe(ctx context.Context, input string) (*Result, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, Path, Args(input)...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("probing %s %w: %s", input, err, strings.TrimSpace(stderr.String()))
+	}
+	return Parse(&stdout)
+}
+
+// Parse - read the JSON printed by ffprobe with Args
+func Parse(r io.Reader) (*Result, error) {
+	var raw struct {
+		Format struct {
+			Filename   string `json:"filename"`
+			FormatName string `json:"format_name"`
+			Duration   string `json:"duration"`
+			Size       string `json:"size"`
+			BitRate    string `json:"bit_rate"`
+		} `json:"format"`
+		Streams []struct {
+			Index         int    `json:"index"`
+			CodecType     string `json:"codec_type"`
+			CodecName     string `json:"codec_name"`
+			Profile       string `json:"profile"`
+			Duration      string `json:"duration"`
+			BitRate       string `json:"bit_rate"`
+			Width         int    `json:"width"`
+			Height        int    `json:"height"`
+			PixFmt        string `json:"pix_fmt"`
+			AvgFrameRate  string `json:"avg_frame_rate"`
+			RFrameRate    string `json:"r_frame_rate"`
+			SampleRate    string `json:"sample_rate"`
+			Channels      int    `json:"channels"`
+			ChannelLayout string `json:"channel_layout"`
+		} `json:"streams"`
+	}
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return nil, fmt.Errorf("parsing ffprobe output %w", err)
+	}
+
+	result := &Result{
+		Format: Format{
+			Filename:   raw.Format.Filename,
+			FormatName: raw.Format.FormatName,
+			Duration:   parseFloat(raw.Format.Duration),
+			Size:       parseInt(raw.Format.Size),
+			BitRate:    parseInt(raw.Format.BitRate),
+		},
+		Streams: make([]Stream, 0, len(raw.Streams)),
+	}
+	for _, s := range raw.Streams {
+		frameRate := parseRate(s.AvgFrameRate)
+		if frameRate == 0 {
+			frameRate = parseRate(s.RFrameRate)
+		}
+		result.Streams = append(result.Streams, Stream{
+			Index:         s.Index,
+			CodecType:     s.CodecType,
+			CodecName:     s.CodecName,
+			Profile:       s.Profile,
+			Duration:      parseFloat(s.Duration),
+			BitRate:       parseInt(s.BitRate),
+			Width:         s.Width,
+			Height:        s.Height,
+			PixFmt:        s.PixFmt,
+			FrameRate:     frameRate,
+			SampleRate:    int(parseInt(s.SampleRate)),
+			Channels:      s.Channels,
+			ChannelLayout: s.ChannelLayout,
+		})
+	}
+	return result, nil
+}
+
+// Duration - length in seconds, the longest stream if the container doesn't report one
+func (r *Result) Duration() float64 {
+	if r.Format.Duration > 0 {
+		return r.Format.Duration
+	}
+	var duration float64
+	for _, s := range r.Streams {
+		if s.Duration > duration {
+			duration = s.Duration
+		}
+	}
+	return duration
+}
+
+// Video - first video stream, nil for audio only inputs
+func (r *Result) Video() *Stream {
+	for i := range r.Streams {
+		if r.Streams[i].CodecType == StreamVideo {
+			return &r.Streams[i]
+		}
+	}
+	return nil
+}
+
+// Audio - every audio stream
+func (r *Result) Audio() []Stream {
+	var audio []Stream
+	for _, s := range r.Streams {
+		if s.CodecType == StreamAudio {
+			audio = append(audio, s)
+		}
+	}
+	return audio
+}
+
+// Scan - allow retrieving of jsonb -> Result
+func (r *Result) Scan(value interface{}) error {
+	if value == nil {
+		return nil
+	}
+	bytes, ok := value.([]byte)
+	if !ok {
+		return errors.New(fmt.Sprint("Failed to unmarshal JSONB value:", value))
+	}
+	return json.Unmarshal(bytes, r)
+}
+
+// Value - allow saving Result as jsonb
+func (r *Result) Value() (driver.Value, error) {
+	if r == nil {
+		return nil, nil
+	}
+	return json.Marshal(r)
+}
+
+func parseFloat(s string) float64 {
+	f, _ := strconv.ParseFloat(s, 64)
+	return f
+}
+
+func parseInt(s string) int64 {
+	i, _ := strconv.ParseInt(s, 10, 64)
+	return i
+}
+
+// parseRate - "30000/1001" as frames per second, 0 for ffprobe's "0/0"
+func parseRate(s string) float64 {
+	split := strings.SplitN(s, "/", 2)
+	if len(split) != 2 {
+		return parseFloat(s)
+	}
+	num, den := parseFloat(split[0]), parseFloat(split[1])
+	if den == 0 {
+		return 0
+	}
+	return num / den
+}
@@ -0,0 +1,87 @@
+package ffprobe_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/palmdalian/transcoder/ffprobe"
+)
+
+const output = `{
+    "streams": [
+        {
+            "index": 0,
+            "codec_name": "h264",
+            "profile": "High",
+            "codec_type": "video",
+            "width": 1920,
+            "height": 1080,
+            "pix_fmt": "yuv420p",
+            "r_frame_rate": "30000/1001",
+            "avg_frame_rate": "30000/1001",
+            "duration": "12.345000",
+            "bit_rate": "4000000"
+        },
+        {
+            "index": 1,
+            "codec_name": "aac",
+            "codec_type": "audio",
+            "sample_rate": "48000",
+            "channels": 6,
+            "channel_layout": "5.1(side)",
+            "r_frame_rate": "0/0",
+            "avg_frame_rate": "0/0",
+            "duration": "12.400000"
+        }
+    ],
+    "format": {
+        "filename": "in.mov",
+        "nb_streams": 2,
+        "format_name": "mov,mp4,m4a,3gp,3g2,mj2",
+        "duration": "12.400000",
+        "size": "6400000",
+        "bit_rate": "4128000"
+    }
+}`
+
+func TestParse(t *testing.T) {
+	result, err := ffprobe.Parse(strings.NewReader(output))
+	if err != nil {
+		t.Fatalf("Parse() = %v", err)
+	}
+	if result.Duration() != 12.4 || result.Format.Size != 6400000 || result.Format.FormatName != "mov,mp4,m4a,3gp,3g2,mj2" {
+		t.Errorf("Format = %+v", result.Format)
+	}
+
+	video := result.Video()
+	if video == nil {
+		t.Fatal("Video() = nil")
+	}
+	if video.Width != 1920 || video.Height != 1080 || video.CodecName != "h264" || video.FrameRate < 29.97 || video.FrameRate > 29.98 {
+		t.Errorf("Video() = %+v, want 1920x1080 h264 at 29.97fps", video)
+	}
+
+	audio := result.Audio()
+	if len(audio) != 1 || audio[0].Channels != 6 || audio[0].ChannelLayout != "5.1(side)" || audio[0].SampleRate != 48000 || audio[0].FrameRate != 0 {
+		t.Errorf("Audio() = %+v, want one 5.1 stream at 48kHz", audio)
+	}
+}
+
+func TestDurationFromStreams(t *testing.T) {
+	result, err := ffprobe.Parse(strings.NewReader(`{"streams": [{"codec_type": "video", "duration": "3.5"}, {"codec_type": "audio", "duration": "4.0"}], "format": {}}`))
+	if err != nil {
+		t.Fatalf("Parse() = %v", err)
+	}
+	if result.Duration() != 4 {
+		t.Errorf("Duration() = %v, want the longest stream", result.Duration())
+	}
+	if result.Video() == nil || len(result.Audio()) != 1 {
+		t.Errorf("Streams = %+v", result.Streams)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	if _, err := ffprobe.Parse(strings.NewReader("in.mov: No such file or directory")); err == nil {
+		t.Error("Parse() = nil, want an error")
+	}
+}
@@ -3,7 +3,8 @@ module github.com/palmdalian/transcoder
 go 1.15
 
 require (
-	github.com/adjust/rmq/v4 v4.0.0
+	github.com/adjust/rmq/v4 v4.0.0 // pinned, queue/poll.go relies on its key layout
+	github.com/alicebob/miniredis/v2 v2.14.1
 	github.com/go-redis/redis/v8 v8.8.2
 	github.com/golang/protobuf v1.5.2 // indirect
 	github.com/google/uuid v1.2.0
@@ -0,0 +1,195 @@
+package transcoder
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// Hook stages
+const (
+	HookPre  = "pre"  // before the command, a failure skips the command
+	HookPost = "post" // after the command succeeded
+)
+
+// Hook - setup or teardown command declared on a Preset
+// Args, Env and Dir are templated with the job params like the preset Args
+// Example: {Name: "mkdir", Path: "mkdir", Args: []string{"-p", "{{output|dir}}"}}
+type Hook struct {
+	Name string   `json:"name,omitempty"` // shown in logs and errors, defaults to Path
+	Path string   `json:"path"`
+	Args []string `json:"args,omitempty"`
+	Env  []string `json:"env,omitempty"`
+	Dir  string   `json:"dir,omitempty"`
+
+	// Always - run this post hook even after the command or an earlier hook failed, for cleanup
+	// It also runs after a timeout or cancelation, keep it short
+	Always bool `json:"always,omitempty"`
+}
+
+// HookCommand - Hook rendered for a job
+type HookCommand struct {
+	Name   string `json:"name"`
+	Always bool   `json:"always,omitempty"`
+	Command
+}
+
+// HookError - a pre or post hook failed
+type HookError struct {
+	Stage string // HookPre or HookPost
+	Name  string
+	Err   error
+}
+
+func (e *HookError) Error() string {
+	return fmt.Sprintf("%s hook %s failed: %v", e.Stage, e.Name, e.Err)
+}
+
+func (e *HookError) Unwrap() error {
+	return e.Err
+}
+
+// templates - Args, Env and Dir in the order newHookCommand reads them back
+func (hook *Hook) templates() []string {
+	templates := make([]string, 0, len(hook.Args)+len(hook.Env)+1)
+	templates = append(templates, hook.Args...)
+	templates = append(templates, hook.Env...)
+	return append(templates, hook.Dir)
+}
+
+func (hook *Hook) newHookCommand(rendered []string) *HookCommand {
+	name := hook.Name
+	if name == "" {
+		name = hook.Path
+	}
+	nArgs, nEnv := len(hook.Args), len(hook.Env)
+	command := &HookCommand{
+		Name:   name,
+		Always: hook.Always,
+		Command: Command{
+			Path: hook.Path,
+			Args: rendered[:nArgs],
+			Dir:  rendered[nArgs+nEnv],
+		},
+	}
+	if nEnv > 0 {
+		command.Env = rendered[nArgs : nArgs+nEnv]
+	}
+	return command
+}
+
+// runHooks - run hooks in order, after the first failure only Always hooks run
+// failed starts in that state, used for post hooks of a failed command
+// Returns the first failure as a *HookError
+func (job *Job) runHooks(ctx context.Context, stage string, hooks []*HookCommand, failed bool) error {
+	var first error
+	for _, hook := range hooks {
+		if failed && !hook.Always {
+			continue
+		}
+		hookCtx := ctx
+		if hook.Always && ctx.Err() != nil {
+			// Clean up after timeouts and cancelation too
+			hookCtx = context.Background()
+		}
+		if err := job.runHook(hookCtx, stage, hook); err != nil {
+			failed = true
+			if first == nil {
+				first = &HookError{Stage: stage, Name: hook.Name, Err: err}
+			}
+		}
+	}
+	return first
+}
+
+// runFailedHooks - run Always post hooks after the job failed
+// Their errors only go to the output so the job keeps its original error
+func (job *Job) runFailedHooks(ctx context.Context) {
+	if err := job.runHooks(ctx, HookPost, job.command.Post, true); err != nil {
+		job.appendErrOutput(err.Error())
+	}
+}
+
+// runHook - run a hook through the job Executor, its output goes to the job output and log prefixed with the stage and name
+func (job *Job) runHook(ctx context.Context, stage string, hook *HookCommand) error {
+	prefix := fmt.Sprintf("[%s %s] ", stage, hook.Name)
+	return job.runSideCommand(ctx, &hook.Command,
+		func(r io.Reader) { readPrefixed(r, prefix, job.appendOutput) },
+		func(r io.Reader) { readPrefixed(r, prefix, job.appendErrOutput) },
+	)
+}
+
+// runSideCommand - run a hook or probe through the job Executor, readStdout and readStderr must read to EOF
+// Kill stops it like the command, ctx kills it right away
+func (job *Job) runSideCommand(ctx context.Context, command *Command, readStdout, readStderr func(io.Reader)) error {
+	exited := make(chan struct{})
+	process, err := job.startSideProcess(ctx, command, exited)
+	if err != nil {
+		return err
+	}
+	defer job.endSideProcess(exited)
+	go func() {
+		select {
+		case <-ctx.Done():
+			process.Signal(os.Kill)
+		case <-exited:
+		}
+	}()
+
+	readers := &sync.WaitGroup{}
+	readers.Add(2)
+	go func() {
+		defer readers.Done()
+		readStdout(process.Stdout())
+	}()
+	go func() {
+		defer readers.Done()
+		readStderr(process.Stderr())
+	}()
+	readers.Wait()
+	if _, err = process.Wait(); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			job.setContextStatus(ctxErr)
+			return fmt.Errorf("stopped: %w", ctxErr)
+		}
+		if job.isStopping() {
+			return fmt.Errorf("%w: %v", ErrKilled, err)
+		}
+	}
+	return err
+}
+
+// startSideProcess - start command as the process Kill stops, exited must be closed by endSideProcess
+// The lock is released by defer so a panicking Executor leaves the job usable
+func (job *Job) startSideProcess(ctx context.Context, command *Command, exited chan struct{}) (Process, error) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	process, err := job.executor().Start(ctx, command, nil)
+	if err != nil {
+		return nil, err
+	}
+	job.process, job.stopped = process, exited
+	return process, nil
+}
+
+// endSideProcess - nothing is left for Kill to stop until the next process starts
+func (job *Job) endSideProcess(exited chan struct{}) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	close(exited)
+	job.process, job.stopped = nil, nil
+}
+
+// readPrefixed - pass every line of r to appendLine with prefix
+func readPrefixed(r io.Reader, prefix string, appendLine func(string)) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		appendLine(prefix + scanner.Text())
+	}
+	// Keep draining so the process can exit
+	io.Copy(ioutil.Discard, r)
+}
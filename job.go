@@ -2,12 +2,12 @@ package transcoder
 
 import (
 	"bufio"
+	"context"
 	"database/sql/driver"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"os"
-	"os/exec"
+	"io"
 	"regexp"
 	"strconv"
 	"strings"
@@ -15,6 +15,7 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/palmdalian/transcoder/ffprobe"
 )
 
 const (
@@ -22,6 +23,9 @@ const (
 	JobStatusInProgress = "inProgress"
 	JobStatusDone       = "done"
 	JobStatusFailed     = "failed"
+	JobStatusTimedOut   = "timedOut"
+	JobStatusCanceled   = "canceled"
+	JobStatusRetrying   = "retrying"
 )
 
 // JobParams - Custom map[string]string for postgres jsob compatibility
@@ -36,15 +40,69 @@ type Job struct {
 	Params        JobParams `json:"params" gorm:"type:jsonb"`
 	CommandOutput string    `json:"commandOutput" gorm:"type:text"`
 
-	mu   sync.RWMutex
-	done chan struct{}
-	err  error
-	info *info
-	cmd  *exec.Cmd
+	// Timeout - max run time of the process. Zero falls back to Preset.Timeout
+	Timeout time.Duration `json:"timeout,omitempty"`
+
+	// OutputLines - lines of stdout and stderr each kept in memory. Zero uses DefaultOutputLines
+	OutputLines int `json:"outputLines,omitempty"`
+	// LogPath - optional file receiving the complete output, readable afterwards with Log
+	LogPath string `json:"logPath,omitempty"`
+
+	// Exit - exit status and resource usage of the last run
+	Exit ExitInfo `json:"exit" gorm:"embedded;embeddedPrefix:exit_"`
+	// ErrorCode - classified reason the last run failed
+	ErrorCode ErrorCode `json:"errorCode,omitempty" gorm:"index"`
+
+	// Priority - queued jobs with a higher Priority run first, negative for backfills
+	Priority int `json:"priority,omitempty"`
+
+	// Probe - ffprobe result for the input, see Preset.ProbeInput
+	Probe *ffprobe.Result `json:"probe,omitempty" gorm:"type:jsonb"`
+
+	// Retry - overrides Preset.Retry when set
+	Retry *RetryPolicy `json:"retry,omitempty" gorm:"-"`
+	// Attempts - history of every run by a Worker
+	Attempts Attempts `json:"attempts" gorm:"type:jsonb"`
+
+	// Classifier - maps failures to an ErrorCode. nil uses DefaultClassifier
+	Classifier Classifier `json:"-" gorm:"-"`
+	// Executor - starts the command. nil uses DefaultExecutor
+	Executor Executor `json:"-" gorm:"-"`
+
+	mu       sync.RWMutex
+	done     chan struct{}
+	exited   chan struct{} // closed once the process has been waited on
+	err      error
+	panicErr *PanicError // recovered from an output reader
+	info     *info
+	process  Process       // the command, or the hook or probe running around it, stopped by Kill
+	stopped  chan struct{} // closed once process has exited
+	stopping bool
+	attempts int       // runs by a Worker since the job was last done
+	retryAt  time.Time // requeued for a retry, not taken before this, guarded by Pool.mu
+
+	// cancelRetry - set while waiting out a retry backoff, Kill calls it to fail the job before it runs again
+	// false if the next attempt has already been taken
+	cancelRetry func() bool
+
+	stdinReader io.Reader // set with SetStdin
+	command     *Command
+
+	subscribers       map[int]chan JobEvent
+	nextSubscriber    int
+	lastProgressEvent time.Time
+	bus               *EventBus // also receives progress and log events, set by the Worker running the job
+
+	logSink   io.Writer // set with SetLogWriter
+	logWriter io.Writer // sinks in use while running
 }
 
 // NewJob - create new job with filled defaults
-func NewJob(preset *Preset, params JobParams) *Job {
+// Returns a *ValidationError if params don't satisfy the preset
+func NewJob(preset *Preset, params JobParams) (*Job, error) {
+	if err := preset.Validate(params); err != nil {
+		return nil, err
+	}
 	return &Job{
 		ID:        uuid.New(),
 		CreatedAt: time.Now(),
@@ -52,111 +110,383 @@ func NewJob(preset *Preset, params JobParams) *Job {
 		PresetID:  preset.ID,
 		Preset:    preset,
 		Params:    params,
-		info:      &info{},
-	}
+		info:      newInfo(DefaultOutputLines),
+	}, nil
 }
 
 type info struct {
-	CurrentTime   float64  `json:"currentTime"`
-	TotalDuration float64  `json:"totalDuration"`
-	Output        []string `json:"output"`
-	ErrOutput     []string `json:"errOutput"`
+	CurrentTime   float64     `json:"currentTime"`
+	TotalDuration float64     `json:"totalDuration"`
+	Progress      Progress    `json:"progress"`
+	Output        *lineBuffer `json:"output"`
+	ErrOutput     *lineBuffer `json:"errOutput"`
+}
+
+func newInfo(lines int) *info {
+	return &info{
+		Output:    newLineBuffer(lines),
+		ErrOutput: newLineBuffer(lines),
+	}
 }
 
 type JobStatus struct {
-	Status  string `json:"status"`
-	Message string `json:"message,omitempty"`
-	Job     *Job   `json:"job"`
+	Status    string    `json:"status"`
+	Message   string    `json:"message,omitempty"`
+	Exit      *ExitInfo `json:"exit,omitempty"` // set once the process has exited
+	ErrorCode ErrorCode `json:"errorCode,omitempty"`
+	Job       *Job      `json:"job"`
 }
 
-var mustacheReg = regexp.MustCompile(`^{{(\S+)}}`)
+// NewJobStatus - status of a copy of job with its exit info and error code
+func NewJobStatus(job *Job, message string) *JobStatus {
+	copied := job.Copy()
+	return &JobStatus{
+		Status:    copied.Status,
+		Message:   message,
+		Exit:      exitInfo(copied),
+		ErrorCode: copied.ErrorCode,
+		Job:       copied,
+	}
+}
 
-// prepare - Replace placeholders with job params
-// Create exec.Cmd and attach new job.info
-func (job *Job) prepare() {
-	args := make([]string, len(job.Preset.Args))
-	// Replace Preset placeholders with job params
-	for i, arg := range job.Preset.Args {
-		vals := mustacheReg.FindStringSubmatch(arg)
-		if len(vals) < 2 {
-			args[i] = arg
-			continue
-		}
-		if preset, ok := job.Params[vals[1]]; ok {
-			args[i] = preset
-		}
+// Copy - the exported fields taken under the lock, safe to read or marshal while the job runs
+// Classifier and Executor are left out
+func (job *Job) Copy() *Job {
+	job.mu.RLock()
+	defer job.mu.RUnlock()
+	params := make(JobParams, len(job.Params))
+	for k, v := range job.Params {
+		params[k] = v
 	}
+	return &Job{
+		ID:            job.ID,
+		CreatedAt:     job.CreatedAt,
+		Status:        job.Status,
+		PresetID:      job.PresetID,
+		Preset:        job.Preset,
+		Params:        params,
+		CommandOutput: job.CommandOutput,
+		Timeout:       job.Timeout,
+		OutputLines:   job.OutputLines,
+		LogPath:       job.LogPath,
+		Exit:          job.Exit,
+		ErrorCode:     job.ErrorCode,
+		Priority:      job.Priority,
+		Probe:         job.Probe,
+		Retry:         job.Retry,
+		Attempts:      append(Attempts(nil), job.Attempts...),
+	}
+}
+
+// setBus - where progress and log events of the job are published
+func (job *Job) setBus(bus *EventBus) {
 	job.mu.Lock()
-	if job.info == nil {
-		job.info = &info{}
+	job.bus = bus
+	job.mu.Unlock()
+}
+
+// prepare - Replace placeholders with job params
+// Resolve the Command and attach new job.info, clearing the result of a previous attempt
+func (job *Job) prepare() error {
+	job.setErrorCode(ErrorCodeNone)
+	command, err := job.Command()
+	if err != nil {
+		return err
 	}
-	job.cmd = exec.Command(job.Preset.Path, args...)
+	command.Interactive = job.stopPolicy().Method == StopQuit
+	job.mu.Lock()
+	job.err = nil
+	job.panicErr = nil
+	job.info = newInfo(job.outputLines())
+	job.command = command
+	job.process = nil
+	job.stopped = nil
+	job.stopping = false
+	job.cancelRetry = nil
+	job.exited = make(chan struct{})
 	job.mu.Unlock()
+	return nil
+}
+
+// executor - job executor if set, otherwise DefaultExecutor
+func (job *Job) executor() Executor {
+	if job.Executor != nil {
+		return job.Executor
+	}
+	return DefaultExecutor
 }
 
 // Run - execute job cmd and collect output
 // will block until job has exited
 func (job *Job) Run() error {
-	job.prepare()
+	return job.RunContext(context.Background())
+}
+
+// RunContext - execute job cmd and collect output
+// The process is stopped with the preset StopPolicy if ctx ends or the job timeout is reached before it exits
+// will block until job has exited
+func (job *Job) RunContext(ctx context.Context) error {
+	defer setDone(job)
+	return job.run(ctx)
+}
+
+// run - execute a single attempt without closing the done channel
+func (job *Job) run(ctx context.Context) error {
+	if timeout := job.timeout(); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	if err := job.prepare(); err != nil {
+		job.mu.Lock()
+		job.err = err
+		job.mu.Unlock()
+		job.classify(err)
+		return err
+	}
+
+	logCloser, err := job.openLog()
+	if err != nil {
+		job.err = err
+		return err
+	}
 
 	defer func() {
-		setDone(job)
-		job.CommandOutput = strings.Join(job.Output(), "\n")
+		job.setExited()
+		job.closeLog(logCloser)
+		output := strings.Join(job.Output(), "\n")
+		job.mu.Lock()
+		job.CommandOutput = output
+		job.mu.Unlock()
 	}()
 
-	stdReader, err := job.cmd.StdoutPipe()
-	if err != nil {
+	if job.atomicOutput() != "" {
+		if err := job.createTempOutput(); err != nil {
+			job.err = err
+			job.classify(err)
+			return err
+		}
+		// Nothing is left once commitOutput moved the files into place
+		defer job.removeTempOutput()
+	}
+
+	// Pre hooks may create the stdin file, run them first
+	if err := job.runHooks(ctx, HookPre, job.command.Pre, false); err != nil {
+		job.runFailedHooks(ctx)
 		job.err = err
+		job.classify(err)
 		return err
 	}
-	scanner := bufio.NewScanner(stdReader)
-	go job.readStdOutput(scanner)
 
-	errReader, err := job.cmd.StderrPipe()
+	if job.probeInput() != "" {
+		if err := job.runProbe(ctx); err != nil {
+			if job.probeRequired() || job.isStopping() {
+				job.runFailedHooks(ctx)
+				job.err = err
+				job.classify(err)
+				return err
+			}
+			// Hosts without ffprobe still run, progress falls back to the Duration ffmpeg prints
+			job.appendErrOutput(fmt.Sprintf("[probe] %v, using the duration from ffmpeg", err))
+		}
+	}
+
+	stdin, stdinCloser, err := job.openStdin(job.command)
 	if err != nil {
+		job.runFailedHooks(ctx)
 		job.err = err
+		job.classify(err)
 		return err
 	}
-	errScanner := bufio.NewScanner(errReader)
-	go job.readErrOutput(errScanner)
+	if stdinCloser != nil {
+		defer stdinCloser.Close()
+	}
 
-	job.mu.Lock()
-	err = job.cmd.Start()
-	job.mu.Unlock()
+	started := time.Now()
+	process, err := job.startProcess(ctx, stdin)
 	if err != nil {
+		job.runFailedHooks(ctx)
 		job.err = err
+		job.classify(err)
 		return err
 	}
 
-	err = job.cmd.Wait()
+	// Pipes must be fully read before Wait closes them
+	readers := &sync.WaitGroup{}
+	readers.Add(2)
+	go job.readStdOutput(readers, bufio.NewScanner(process.Stdout()))
+	go job.readErrOutput(readers, bufio.NewScanner(process.Stderr()))
+
+	go job.stopOnDone(ctx, job.exited)
+	readers.Wait()
+	exit, err := process.Wait()
+	if exit.WallTime == 0 {
+		exit.WallTime = time.Since(started)
+	}
+	job.mu.Lock()
+	job.Exit = exit
+	job.mu.Unlock()
+	job.setExited()
+
+	if job.isStopping() {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			job.setContextStatus(ctxErr)
+			err = fmt.Errorf("stopped: %w", ctxErr)
+		} else if err == nil {
+			err = ErrKilled
+		} else {
+			err = fmt.Errorf("%w: %v", ErrKilled, err)
+		}
+	}
+	if panicErr := job.recovered(); panicErr != nil {
+		err = panicErr
+	}
+	if err != nil {
+		job.runFailedHooks(ctx)
+	} else {
+		err = job.runHooks(ctx, HookPost, job.command.Post, false)
+	}
+	// Verify before the output is moved into place
+	if err == nil && job.Preset.Verify != nil {
+		err = job.verify(ctx)
+	}
+	if err == nil && job.atomicOutput() != "" {
+		err = job.commitOutput()
+	}
 	if err != nil {
 		job.err = err
+		job.classify(err)
 		return err
 	}
 
-	job.Status = JobStatusDone
+	job.setStatus(JobStatusDone)
 	return nil
 }
 
+// startProcess - start the command with the job's Executor
+// The lock is released by defer so a panicking Executor leaves the job usable
+func (job *Job) startProcess(ctx context.Context, stdin io.Reader) (Process, error) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	process, err := job.executor().Start(ctx, job.command, stdin)
+	job.process, job.stopped = process, job.exited
+	return process, err
+}
+
+// timeout - job timeout if set, otherwise the preset timeout
+func (job *Job) timeout() time.Duration {
+	if job.Timeout > 0 {
+		return job.Timeout
+	}
+	if job.Preset != nil {
+		return job.Preset.Timeout
+	}
+	return 0
+}
+
+// setExited - mark the process as finished
+// Subscribers stay open for retries until setDone
+func (job *Job) setExited() {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	if !job.hasExited() {
+		close(job.exited)
+	}
+}
+
+// stopOnDone - stop the process if ctx ends before it exits
+func (job *Job) stopOnDone(ctx context.Context, exited <-chan struct{}) {
+	select {
+	case <-ctx.Done():
+		job.mu.Lock()
+		err := job.stop()
+		job.mu.Unlock()
+		if err != nil {
+			job.appendErrOutput(fmt.Sprintf("stopping job %v", err))
+		}
+	case <-exited:
+	}
+}
+
+// isStopping - true once Kill or the context has asked the process to stop
+func (job *Job) isStopping() bool {
+	job.mu.RLock()
+	defer job.mu.RUnlock()
+	return job.stopping
+}
+
+// setContextStatus - record why the context ended the process
+func (job *Job) setContextStatus(err error) {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		job.setStatus(JobStatusTimedOut)
+	case errors.Is(err, context.Canceled):
+		job.setStatus(JobStatusCanceled)
+	}
+}
+
+// setStatus - change Status under the lock so Copy can run while the job does
+// Status, ErrorCode, Exit, CommandOutput, Attempts and Probe are only written with the lock held
+func (job *Job) setStatus(status string) {
+	job.mu.Lock()
+	job.Status = status
+	job.mu.Unlock()
+}
+
+// status - Status read under the lock, for goroutines other than the one running the job
+func (job *Job) status() string {
+	job.mu.RLock()
+	defer job.mu.RUnlock()
+	return job.Status
+}
+
+func (job *Job) setErrorCode(code ErrorCode) {
+	job.mu.Lock()
+	job.ErrorCode = code
+	job.mu.Unlock()
+}
+
 // Reset - reset job to pre-run state
 func (job *Job) Reset() {
 	job.mu.Lock()
 	defer job.mu.Unlock()
 	job.Status = JobStatusSubmitted
 	job.CommandOutput = ""
-	if job.done != nil {
+	job.Exit = ExitInfo{}
+	job.ErrorCode = ErrorCodeNone
+	job.Probe = nil
+	// Release anyone waiting on the previous run, the next run gets a new channel
+	if job.done != nil && !isClosed(job.done) {
 		close(job.done)
 	}
-	job.info = &info{}
+	job.done = nil
+	job.attempts = 0
+	job.closeSubscribers()
+	job.info = newInfo(job.outputLines())
 }
 
-// setDone - close done channel if exists
+// setDone - close done channel and subscriber channels, creating done so later Done calls don't block
 func setDone(job *Job) {
 	job.mu.Lock()
 	defer job.mu.Unlock()
-	if job.done != nil {
+	if job.done == nil {
+		job.done = make(chan struct{})
+	}
+	if !isClosed(job.done) {
 		close(job.done)
 	}
+	job.attempts = 0
+	job.cancelRetry = nil
+	job.closeSubscribers()
+}
+
+func isClosed(c chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
 }
 
 // Done - channel that blocks until process has finished
@@ -183,45 +513,46 @@ func (job *Job) Err() error {
 	return err
 }
 
-// Kill a running process
+// Kill a running process using the preset StopPolicy, a running pre or post hook or probe is stopped the same way
+// A job waiting to be retried is failed without running again
+// Returns once the process has been asked to stop, use Wait to block until it exits
 func (job *Job) Kill() error {
+	if cancel := job.takeCancelRetry(); cancel != nil && cancel() {
+		return nil
+	}
 	job.mu.Lock()
 	defer job.mu.Unlock()
-	if job.cmd == nil || job.cmd.Process == nil {
-		return fmt.Errorf("no job to kill %v", job.cmd)
-	}
-	err := job.cmd.Process.Signal(os.Kill)
-	if err != nil {
+	if err := job.stop(); err != nil {
 		return err
 	}
 	job.Status = JobStatusFailed
 	return nil
 }
 
-var microsecondReg = regexp.MustCompile(`^out_time_ms=(\d+)$`)
-
-func (job *Job) readStdOutput(scanner *bufio.Scanner) {
+func (job *Job) readStdOutput(wg *sync.WaitGroup, scanner *bufio.Scanner) {
+	defer wg.Done()
+	defer job.recoverReader(scanner)
+	parser := &progressParser{}
 	for scanner.Scan() {
 		text := scanner.Text()
 		job.appendOutput(text)
-		vals := microsecondReg.FindStringSubmatch(text)
-		if len(vals) < 2 {
-			continue
-		}
-		if ms, err := strconv.ParseInt(vals[1], 10, 64); err == nil {
-			job.setCurrentTime(float64(ms) / 1000000)
+		if parser.parseLine(text) {
+			job.setProgress(parser.current)
 		}
 	}
 }
 
 var timecodeReg = regexp.MustCompile(`^\s*Duration:\s*([0-9:.]+),`)
 
-func (job *Job) readErrOutput(scanner *bufio.Scanner) {
+func (job *Job) readErrOutput(wg *sync.WaitGroup, scanner *bufio.Scanner) {
+	defer wg.Done()
+	defer job.recoverReader(scanner)
 	for scanner.Scan() {
 		text := scanner.Text()
 		job.appendErrOutput(text)
 		vals := timecodeReg.FindStringSubmatch(text)
-		if len(vals) < 2 {
+		// ffprobe knows better than whichever input ffmpeg printed last
+		if len(vals) < 2 || job.probedDuration() > 0 {
 			continue
 		}
 		dur := parseDurationFromTimecode(vals[1])
@@ -255,26 +586,47 @@ func parseDurationFromTimecode(timecode string) float64 {
 
 func (job *Job) appendOutput(output string) {
 	job.mu.Lock()
-	defer job.mu.Unlock()
-	job.info.Output = append(job.info.Output, output)
+	job.ensureInfo()
+	job.info.Output.add(output)
+	job.writeLog(StreamStdout, output)
+	event := job.publish(JobEvent{Type: JobEventLog, Stream: StreamStdout, Line: output})
+	bus := job.bus
+	job.mu.Unlock()
+	// Outside the lock, a blocking subscriber must not hold up Info or Kill
+	bus.Publish(event)
 }
 
 func (job *Job) appendErrOutput(output string) {
 	job.mu.Lock()
-	defer job.mu.Unlock()
-	job.info.ErrOutput = append(job.info.ErrOutput, output)
+	job.ensureInfo()
+	job.info.ErrOutput.add(output)
+	job.writeLog(StreamStderr, output)
+	event := job.publish(JobEvent{Type: JobEventLog, Stream: StreamStderr, Line: output})
+	bus := job.bus
+	job.mu.Unlock()
+	bus.Publish(event)
 }
 
 func (job *Job) setTotalDuration(duration float64) {
 	job.mu.Lock()
 	defer job.mu.Unlock()
+	job.ensureInfo()
 	job.info.TotalDuration = duration
+	job.info.Progress.calculate(duration)
 }
 
-func (job *Job) setCurrentTime(current float64) {
+func (job *Job) setProgress(progress Progress) {
 	job.mu.Lock()
-	defer job.mu.Unlock()
-	job.info.CurrentTime = current
+	job.ensureInfo()
+	progress.calculate(job.info.TotalDuration)
+	job.info.Progress = progress
+	job.info.CurrentTime = progress.OutTime
+	event, ok := job.publishProgress(progress)
+	bus := job.bus
+	job.mu.Unlock()
+	if ok {
+		bus.Publish(event)
+	}
 }
 
 // InfoString - return json string of all collected info from exec.Cmd process
@@ -288,20 +640,42 @@ func (job *Job) Info() string {
 	return string(b)
 }
 
-// Output - return any messages collected from stdin
+// Progress - latest progress snapshot parsed from ffmpeg -progress output
+func (job *Job) Progress() Progress {
+	job.mu.RLock()
+	defer job.mu.RUnlock()
+	if job.info == nil {
+		return Progress{}
+	}
+	return job.info.Progress
+}
+
+// Output - return the retained messages collected from stdout
 func (job *Job) Output() []string {
 	job.mu.RLock()
-	o := job.info.Output
-	job.mu.RUnlock()
-	return o
+	defer job.mu.RUnlock()
+	if job.info == nil {
+		return nil
+	}
+	return job.info.Output.all()
 }
 
-// ErrOutput - return any messages collected from stderr
+// ErrOutput - return the retained messages collected from stderr
 func (job *Job) ErrOutput() []string {
 	job.mu.RLock()
-	e := job.info.ErrOutput
-	job.mu.RUnlock()
-	return e
+	defer job.mu.RUnlock()
+	if job.info == nil {
+		return nil
+	}
+	return job.info.ErrOutput.all()
+}
+
+// ensureInfo - jobs decoded from JSON or a db have no info until they run
+// job.mu must be held by the caller
+func (job *Job) ensureInfo() {
+	if job.info == nil {
+		job.info = newInfo(job.outputLines())
+	}
 }
 
 // Scan - allow retrieving of jsonb -> JobParams
@@ -0,0 +1,779 @@
+package transcoder_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/palmdalian/transcoder"
+	"github.com/palmdalian/transcoder/ffprobe"
+	"github.com/palmdalian/transcoder/transcodertest"
+)
+
+func TestJobRun(t *testing.T) {
+	executor := transcodertest.NewExecutor(transcodertest.Progress)
+	job := transcodertest.NewJob(executor)
+
+	if err := job.Run(); err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+	if job.Status != transcoder.JobStatusDone {
+		t.Errorf("Status = %q, want %q", job.Status, transcoder.JobStatusDone)
+	}
+
+	progress := job.Progress()
+	if !progress.Done || progress.Percent != 100 {
+		t.Errorf("Progress() = %+v, want done at 100%%", progress)
+	}
+	if progress.Duration != 10 || progress.OutTime != 10 || progress.Speed != 2 {
+		t.Errorf("Progress() = %+v, want duration 10, outTime 10, speed 2", progress)
+	}
+
+	commands := executor.Commands()
+	if len(commands) != 1 {
+		t.Fatalf("started %d commands, want 1", len(commands))
+	}
+	want := "ffmpeg -y -progress - -nostats -i in.mov -c copy out.mp4"
+	if got := commands[0].String(); got != want {
+		t.Errorf("command = %q, want %q", got, want)
+	}
+	select {
+	case <-job.Done():
+	default:
+		t.Error("Done() not closed after Run")
+	}
+}
+
+func TestJobRunFailure(t *testing.T) {
+	tests := []struct {
+		name   string
+		script transcodertest.Script
+		code   transcoder.ErrorCode
+	}{
+		{"no such file", transcodertest.Script{Stderr: []string{"in.mov: No such file or directory"}, ExitCode: 1}, transcoder.ErrorCodeNoSuchFile},
+		{"invalid data", transcodertest.Script{Stderr: []string{"in.mov: Invalid data found when processing input"}, ExitCode: 1}, transcoder.ErrorCodeInvalidData},
+		{"unknown encoder", transcodertest.Script{Stderr: []string{"Unknown encoder 'libfoo'"}, ExitCode: 1}, transcoder.ErrorCodeUnknownEncoder},
+		{"disk full", transcodertest.Script{Stderr: []string{"av_interleaved_write_frame(): No space left on device", "Conversion failed!"}, ExitCode: 1}, transcoder.ErrorCodeDiskFull},
+		{"conversion failed", transcodertest.Script{Stderr: []string{"Error while filtering", "Conversion failed!"}, ExitCode: 1}, transcoder.ErrorCodeConversionFailed},
+		{"unrecognized", transcodertest.Script{ExitCode: 1}, transcoder.ErrorCodeUnknown},
+		{"start error", transcodertest.Script{StartErr: errors.New("boom")}, transcoder.ErrorCodeUnknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job := transcodertest.NewJob(transcodertest.NewExecutor(tt.script))
+			if err := job.Run(); err == nil {
+				t.Fatal("Run() = nil, want error")
+			}
+			if job.ErrorCode != tt.code {
+				t.Errorf("ErrorCode = %q, want %q", job.ErrorCode, tt.code)
+			}
+			if job.Err() == nil {
+				t.Error("Err() = nil after failed run")
+			}
+		})
+	}
+}
+
+func TestJobBadTemplate(t *testing.T) {
+	executor := transcodertest.NewExecutor(transcodertest.Progress)
+	job := transcodertest.NewJob(executor)
+	// Jobs decoded from a queue skip NewJob validation
+	job.Preset.Args = []string{"-i", "{{input|bogus}}", "{{output}}"}
+
+	var templateErr *transcoder.TemplateError
+	if err := job.Run(); !errors.As(err, &templateErr) {
+		t.Fatalf("Run() = %v, want a TemplateError", err)
+	}
+	if job.ErrorCode != transcoder.ErrorCodeBadParams || job.ErrorCode.Transient() {
+		t.Errorf("ErrorCode = %q, want %q which is not retried", job.ErrorCode, transcoder.ErrorCodeBadParams)
+	}
+	if n := len(executor.Commands()); n != 0 {
+		t.Errorf("started %d commands, want none", n)
+	}
+}
+
+func TestNewJobStatus(t *testing.T) {
+	job := transcodertest.NewJob(transcodertest.NewExecutor(transcodertest.Script{Stderr: []string{"in.mov: No such file or directory"}, ExitCode: 1}))
+	if stat := transcoder.NewJobStatus(job, "queued"); stat.Exit != nil || stat.ErrorCode != "" {
+		t.Errorf("NewJobStatus() = %+v before running, want no exit or error code", stat)
+	}
+	job.Run()
+
+	stat := transcoder.NewJobStatus(job, "Job is not running")
+	if stat.Exit == nil || stat.Exit.ExitCode != 1 {
+		t.Errorf("Exit = %+v, want exit code 1", stat.Exit)
+	}
+	if stat.ErrorCode != transcoder.ErrorCodeNoSuchFile {
+		t.Errorf("ErrorCode = %q, want %q", stat.ErrorCode, transcoder.ErrorCodeNoSuchFile)
+	}
+	if stat.Job == job || stat.Job.ID != job.ID {
+		t.Error("Job is not a copy of the job")
+	}
+}
+
+func TestJobRunContextTimeout(t *testing.T) {
+	job := transcodertest.NewJob(transcodertest.NewExecutor(transcodertest.Script{Hang: true}))
+	job.Timeout = 50 * time.Millisecond
+
+	err := job.RunContext(context.Background())
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("RunContext() = %v, want deadline exceeded", err)
+	}
+	if job.Status != transcoder.JobStatusTimedOut {
+		t.Errorf("Status = %q, want %q", job.Status, transcoder.JobStatusTimedOut)
+	}
+	if job.ErrorCode != transcoder.ErrorCodeTimedOut {
+		t.Errorf("ErrorCode = %q, want %q", job.ErrorCode, transcoder.ErrorCodeTimedOut)
+	}
+}
+
+func TestJobRunContextCanceled(t *testing.T) {
+	job := transcodertest.NewJob(transcodertest.NewExecutor(transcodertest.Script{Hang: true}))
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	if err := job.RunContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("RunContext() = %v, want canceled", err)
+	}
+	if job.Status != transcoder.JobStatusCanceled {
+		t.Errorf("Status = %q, want %q", job.Status, transcoder.JobStatusCanceled)
+	}
+}
+
+func TestJobKill(t *testing.T) {
+	tests := []struct {
+		name   string
+		script transcodertest.Script
+		policy transcoder.StopPolicy
+		signal string
+	}{
+		{"quit", transcodertest.Script{Hang: true}, transcoder.StopPolicy{Method: transcoder.StopQuit, GracePeriod: time.Second}, ""},
+		{"interrupt", transcodertest.Script{Hang: true}, transcoder.StopPolicy{Method: transcoder.StopInterrupt, GracePeriod: time.Second}, ""},
+		{"escalate", transcodertest.Script{Hang: true, IgnoreInterrupt: true}, transcoder.StopPolicy{Method: transcoder.StopQuit, GracePeriod: 50 * time.Millisecond}, "killed"},
+		{"kill", transcodertest.Script{Hang: true, IgnoreInterrupt: true}, transcoder.StopPolicy{Method: transcoder.StopKill}, "killed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job := transcodertest.NewJob(transcodertest.NewExecutor(tt.script))
+			policy := tt.policy
+			job.Preset.Stop = &policy
+
+			errChan := make(chan error)
+			go func() { errChan <- job.Run() }()
+			waitFor(t, func() bool { return job.Kill() == nil })
+
+			select {
+			case err := <-errChan:
+				if !errors.Is(err, transcoder.ErrKilled) {
+					t.Errorf("Run() = %v, want ErrKilled", err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("job still running after Kill")
+			}
+			if job.Exit.Signal != tt.signal {
+				t.Errorf("Exit.Signal = %q, want %q", job.Exit.Signal, tt.signal)
+			}
+			if job.ErrorCode != transcoder.ErrorCodeKilled {
+				t.Errorf("ErrorCode = %q, want %q", job.ErrorCode, transcoder.ErrorCodeKilled)
+			}
+		})
+	}
+}
+
+func TestJobKillSideCommand(t *testing.T) {
+	tests := []struct {
+		name    string
+		hanging string
+	}{
+		{"pre hook", "download"},
+		{"probe", ffprobe.Path},
+		{"post hook", "upload"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			executor := transcodertest.NewExecutor(transcodertest.Script{})
+			executor.Script = func(cmd *transcoder.Command) transcodertest.Script {
+				switch cmd.Path {
+				case tt.hanging:
+					return transcodertest.Script{Hang: true}
+				case ffprobe.Path:
+					return transcodertest.Probe(10 * time.Second)
+				case "ffmpeg":
+					return transcodertest.Progress
+				}
+				return transcodertest.Script{}
+			}
+			job := transcodertest.NewJob(executor)
+			job.Preset.ProbeInput = "input"
+			job.Preset.Pre = []transcoder.Hook{{Path: "download", Args: []string{"{{input}}"}}}
+			job.Preset.Post = []transcoder.Hook{
+				{Path: "upload", Args: []string{"{{output}}"}},
+				{Path: "rm", Args: []string{"-f", "{{input}}"}, Always: true},
+			}
+
+			errChan := make(chan error)
+			go func() { errChan <- job.Run() }()
+			waitFor(t, func() bool {
+				commands := executor.Commands()
+				return len(commands) > 0 && commands[len(commands)-1].Path == tt.hanging
+			})
+			waitFor(t, func() bool { return job.Kill() == nil })
+
+			select {
+			case err := <-errChan:
+				if !errors.Is(err, transcoder.ErrKilled) {
+					t.Errorf("Run() = %v, want ErrKilled", err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("%s still running after Kill", tt.hanging)
+			}
+			if job.ErrorCode != transcoder.ErrorCodeKilled {
+				t.Errorf("ErrorCode = %q, want %q", job.ErrorCode, transcoder.ErrorCodeKilled)
+			}
+			commands := executor.Commands()
+			if last := commands[len(commands)-1].Path; last != "rm" {
+				t.Errorf("last command %q, want the Always hook to clean up", last)
+			}
+		})
+	}
+}
+
+func TestJobSubscribe(t *testing.T) {
+	job := transcodertest.NewJob(transcodertest.NewExecutor(transcodertest.Progress))
+	events, unsubscribe := job.Subscribe()
+	defer unsubscribe()
+
+	go job.Run()
+
+	var last *transcoder.Progress
+	var lines int
+	for event := range events {
+		switch event.Type {
+		case transcoder.JobEventProgress:
+			last = event.Progress
+		case transcoder.JobEventLog:
+			lines++
+		}
+	}
+	if last == nil || !last.Done {
+		t.Errorf("last progress event = %+v, want done", last)
+	}
+	if lines == 0 {
+		t.Error("no log events received")
+	}
+}
+
+func TestJobOutputRetention(t *testing.T) {
+	job := transcodertest.NewJob(transcodertest.NewExecutor(transcodertest.Progress))
+	job.OutputLines = 3
+	job.LogPath = t.TempDir() + "/job.log"
+
+	if err := job.Run(); err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+	output := job.Output()
+	if len(output) != 3 || output[2] != "progress=end" {
+		t.Errorf("Output() = %v, want last 3 lines", output)
+	}
+
+	lines, err := job.Log(0, 0)
+	if err != nil {
+		t.Fatalf("Log() = %v", err)
+	}
+	stdout := 0
+	for _, line := range lines {
+		if line.Stream == transcoder.StreamStdout {
+			stdout++
+		}
+	}
+	if stdout != 5*11 {
+		t.Errorf("Log() has %d stdout lines, want %d", stdout, 5*11)
+	}
+
+	page, err := job.Log(2, 2)
+	if err != nil {
+		t.Fatalf("Log(2, 2) = %v", err)
+	}
+	if len(page) != 2 || page[0].N != 2 {
+		t.Errorf("Log(2, 2) = %+v, want lines 2 and 3", page)
+	}
+}
+
+func TestJobDecodedOutput(t *testing.T) {
+	var job transcoder.Job
+	if err := json.Unmarshal([]byte(`{"status":"done"}`), &job); err != nil {
+		t.Fatal(err)
+	}
+	if output := job.Output(); output != nil {
+		t.Errorf("Output() = %v, want nil", output)
+	}
+	if output := job.ErrOutput(); output != nil {
+		t.Errorf("ErrOutput() = %v, want nil", output)
+	}
+}
+
+func TestJobHooks(t *testing.T) {
+	tests := []struct {
+		name      string
+		scripts   map[string]transcodertest.Script
+		wantErr   string
+		wantPaths []string
+	}{
+		{
+			name:      "success",
+			wantPaths: []string{"mkdir", "test", "ffmpeg", "mv", "rm"},
+		},
+		{
+			name:      "pre hook fails",
+			scripts:   map[string]transcodertest.Script{"test": {Stderr: []string{"in.mov missing"}, ExitCode: 1}},
+			wantErr:   transcoder.HookPre,
+			wantPaths: []string{"mkdir", "test", "rm"},
+		},
+		{
+			name:      "command fails",
+			scripts:   map[string]transcodertest.Script{"ffmpeg": {ExitCode: 1}},
+			wantPaths: []string{"mkdir", "test", "ffmpeg", "rm"},
+		},
+		{
+			name:      "post hook fails",
+			scripts:   map[string]transcodertest.Script{"mv": {ExitCode: 1}},
+			wantErr:   transcoder.HookPost,
+			wantPaths: []string{"mkdir", "test", "ffmpeg", "mv", "rm"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			executor := transcodertest.NewExecutor(transcodertest.Script{})
+			executor.Script = func(cmd *transcoder.Command) transcodertest.Script {
+				if script, ok := tt.scripts[cmd.Path]; ok {
+					return script
+				}
+				if cmd.Path == "ffmpeg" {
+					return transcodertest.Progress
+				}
+				return transcodertest.Script{}
+			}
+			job := transcodertest.NewJob(executor)
+			job.Preset.Pre = []transcoder.Hook{
+				{Path: "mkdir", Args: []string{"-p", "{{output|dir}}"}},
+				{Name: "input exists", Path: "test", Args: []string{"-f", "{{input}}"}},
+			}
+			job.Preset.Post = []transcoder.Hook{
+				{Name: "move", Path: "mv", Args: []string{"{{output}}.tmp", "{{output}}"}},
+				{Name: "cleanup", Path: "rm", Args: []string{"-f", "{{output}}.tmp"}, Always: true},
+			}
+
+			err := job.Run()
+			var paths []string
+			for _, cmd := range executor.Commands() {
+				paths = append(paths, cmd.Path)
+			}
+			if !equal(paths, tt.wantPaths) {
+				t.Errorf("commands = %v, want %v", paths, tt.wantPaths)
+			}
+
+			var hookErr *transcoder.HookError
+			switch {
+			case tt.wantErr != "":
+				if !errors.As(err, &hookErr) || hookErr.Stage != tt.wantErr {
+					t.Fatalf("Run() = %v, want a %s HookError", err, tt.wantErr)
+				}
+				if job.ErrorCode != transcoder.ErrorCodeHookFailed {
+					t.Errorf("ErrorCode = %q, want %q", job.ErrorCode, transcoder.ErrorCodeHookFailed)
+				}
+			case tt.scripts["ffmpeg"].ExitCode != 0:
+				if err == nil || errors.As(err, &hookErr) {
+					t.Errorf("Run() = %v, want the command error", err)
+				}
+			case err != nil:
+				t.Fatalf("Run() = %v", err)
+			}
+		})
+	}
+}
+
+func TestJobHookOutput(t *testing.T) {
+	executor := transcodertest.NewExecutor(transcodertest.Progress)
+	executor.Script = func(cmd *transcoder.Command) transcodertest.Script {
+		if cmd.Path == "test" {
+			return transcodertest.Script{Stderr: []string{"in.mov missing"}, ExitCode: 1}
+		}
+		return transcodertest.Progress
+	}
+	job := transcodertest.NewJob(executor)
+	job.Preset.Pre = []transcoder.Hook{{Name: "input exists", Path: "test", Args: []string{"-f", "{{input}}"}}}
+
+	command, err := job.Command()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(command.Pre) != 1 || command.Pre[0].String() != "test -f in.mov" {
+		t.Errorf("Command().Pre = %+v, want test -f in.mov", command.Pre)
+	}
+
+	job.Run()
+	errOutput := strings.Join(job.ErrOutput(), "\n")
+	if !strings.Contains(errOutput, "[pre input exists] in.mov missing") {
+		t.Errorf("ErrOutput() = %q, want the hook output", errOutput)
+	}
+	if want := "pre hook input exists failed: exit status 1"; job.Err() == nil || job.Err().Error() != want {
+		t.Errorf("Err() = %v, want %q", job.Err(), want)
+	}
+}
+
+func TestJobAtomicOutput(t *testing.T) {
+	tests := []struct {
+		name     string
+		script   transcodertest.Script
+		wantFile bool
+	}{
+		{name: "success", script: transcodertest.Progress, wantFile: true},
+		{name: "failure", script: transcodertest.Script{ExitCode: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			output := filepath.Join(dir, "out.mp4")
+			var written string
+			executor := transcodertest.NewExecutor(tt.script)
+			executor.Script = func(cmd *transcoder.Command) transcodertest.Script {
+				// Write a partial file where the command was told to
+				written = cmd.Args[len(cmd.Args)-1]
+				if err := ioutil.WriteFile(written, []byte("partial"), 0644); err != nil {
+					t.Error(err)
+				}
+				return tt.script
+			}
+			job := transcodertest.NewJob(executor)
+			job.Preset.AtomicOutput = "output"
+			job.Params["output"] = output
+
+			job.Run()
+			if written == output || filepath.Base(written) != "out.mp4" {
+				t.Errorf("command wrote %q, want a temporary out.mp4", written)
+			}
+			if _, err := os.Stat(output); (err == nil) != tt.wantFile {
+				t.Errorf("output exists = %v, want %v", err == nil, tt.wantFile)
+			}
+			if _, err := os.Stat(filepath.Dir(written)); !os.IsNotExist(err) {
+				t.Errorf("temporary output dir left behind (%v)", err)
+			}
+		})
+	}
+}
+
+func TestJobProbe(t *testing.T) {
+	executor := transcodertest.NewExecutor(transcodertest.Progress)
+	executor.Script = func(cmd *transcoder.Command) transcodertest.Script {
+		if cmd.Path == ffprobe.Path {
+			return transcodertest.Probe(20 * time.Second)
+		}
+		// Prints "Duration: 00:00:10.00", which the probe overrides
+		return transcodertest.Progress
+	}
+	job := transcodertest.NewJob(executor)
+	job.Preset.ProbeInput = "input"
+
+	if err := job.Run(); err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+	if job.Probe == nil || job.Probe.Duration() != 20 {
+		t.Fatalf("Probe = %+v, want a 20 second input", job.Probe)
+	}
+	if video := job.Probe.Video(); video == nil || video.Width != 1920 || video.Height != 1080 {
+		t.Errorf("Probe.Video() = %+v, want 1920x1080", video)
+	}
+	if progress := job.Progress(); progress.Duration != 20 {
+		t.Errorf("Progress() = %+v, want a duration of 20", progress)
+	}
+
+	commands := executor.Commands()
+	if len(commands) != 2 || commands[0].String() != "ffprobe -v error -print_format json -show_format -show_streams in.mov" {
+		t.Errorf("commands = %v, want ffprobe of in.mov first", commands)
+	}
+}
+
+func TestJobProbeFailure(t *testing.T) {
+	newJob := func() (*transcoder.Job, *transcodertest.Executor) {
+		executor := transcodertest.NewExecutor(transcodertest.Progress)
+		executor.Script = func(cmd *transcoder.Command) transcodertest.Script {
+			if cmd.Path == ffprobe.Path {
+				return transcodertest.Script{Stderr: []string{"in.mov: No such file or directory"}, ExitCode: 1}
+			}
+			return transcodertest.Progress
+		}
+		job := transcodertest.NewJob(executor)
+		job.Preset.ProbeInput = "input"
+		return job, executor
+	}
+
+	// Without ffprobe the job still runs and takes the duration ffmpeg prints
+	job, executor := newJob()
+	if err := job.Run(); err != nil {
+		t.Fatalf("Run() = %v, want the probe failure ignored", err)
+	}
+	if progress := job.Progress(); progress.Duration != 10 {
+		t.Errorf("Progress() = %+v, want the duration of 10 from stderr", progress)
+	}
+	if errOutput := strings.Join(job.ErrOutput(), "\n"); !strings.Contains(errOutput, "[probe] probing in.mov") {
+		t.Errorf("ErrOutput() = %q, want the probe error", errOutput)
+	}
+	if n := len(executor.Commands()); n != 2 {
+		t.Errorf("started %d commands, want ffprobe and ffmpeg", n)
+	}
+
+	// Verifying the duration needs the probe, so its failure is fatal
+	job, executor = newJob()
+	job.Preset.Verify = &transcoder.Verification{Output: "{{output}}", DurationTolerance: 0.5}
+	if err := job.Run(); err == nil {
+		t.Fatal("Run() = nil, want the probe error")
+	}
+	if job.ErrorCode != transcoder.ErrorCodeNoSuchFile {
+		t.Errorf("ErrorCode = %q, want %q", job.ErrorCode, transcoder.ErrorCodeNoSuchFile)
+	}
+	if n := len(executor.Commands()); n != 1 {
+		t.Errorf("started %d commands, want only ffprobe", n)
+	}
+}
+
+func TestJobVerify(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string // written to the output unless empty, "-" writes an empty file
+		verify  transcoder.Verification
+		probe   transcodertest.Script // ffprobe of the output
+		want    string                // expected problem, empty for success
+	}{
+		{name: "exists", content: "data", want: ""},
+		{name: "missing", want: "does not exist"},
+		{name: "empty", content: "-", verify: transcoder.Verification{NonEmpty: true}, want: "is empty"},
+		{
+			name:    "matches",
+			content: "data",
+			verify:  transcoder.Verification{NonEmpty: true, DurationTolerance: 0.5, VideoCodec: "h264", AudioCodec: "aac", Width: 1920, Height: 1080},
+			probe:   transcodertest.Probe(10200 * time.Millisecond),
+		},
+		{
+			name:    "unreadable",
+			content: "data",
+			verify:  transcoder.Verification{Probe: true},
+			probe:   transcodertest.Script{Stderr: []string{"Invalid data found when processing input"}, ExitCode: 1},
+			want:    "not readable by ffprobe",
+		},
+		{
+			name:    "truncated",
+			content: "data",
+			verify:  transcoder.Verification{DurationTolerance: 0.5},
+			probe:   transcodertest.Probe(4 * time.Second),
+			want:    "duration 4.00s differs from the input 10.00s by more than 0.50s",
+		},
+		{
+			name:    "wrong codec",
+			content: "data",
+			verify:  transcoder.Verification{VideoCodec: "hevc", Height: 720},
+			probe:   transcodertest.Probe(10 * time.Second),
+			want:    "video codec h264, want hevc, height 1080, want 720",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := filepath.Join(t.TempDir(), "out")
+			executor := transcodertest.NewExecutor(transcodertest.Progress)
+			executor.Script = func(cmd *transcoder.Command) transcodertest.Script {
+				switch {
+				case cmd.Path == ffprobe.Path && cmd.Args[len(cmd.Args)-1] == "in.mov":
+					return transcodertest.Probe(10 * time.Second)
+				case cmd.Path == ffprobe.Path:
+					return tt.probe
+				}
+				if tt.content != "" {
+					if err := ioutil.WriteFile(output+".mp4", []byte(strings.TrimPrefix(tt.content, "-")), 0644); err != nil {
+						t.Error(err)
+					}
+				}
+				return transcodertest.Progress
+			}
+			job := transcodertest.NewJob(executor)
+			job.Params["output"] = output
+			job.Preset.ProbeInput = "input"
+			verify := tt.verify
+			verify.Output = "{{output}}.mp4"
+			job.Preset.Verify = &verify
+
+			err := job.Run()
+			if tt.want == "" {
+				if err != nil || job.Status != transcoder.JobStatusDone {
+					t.Errorf("Run() = %v, Status = %q, want done", err, job.Status)
+				}
+				return
+			}
+			var verr *transcoder.VerificationError
+			if !errors.As(err, &verr) || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("Run() = %v, want a VerificationError with %q", err, tt.want)
+			}
+			if verr.Path != output+".mp4" {
+				t.Errorf("Path = %q, want %q", verr.Path, output+".mp4")
+			}
+			if job.Status == transcoder.JobStatusDone || job.ErrorCode != transcoder.ErrorCodeVerificationFailed {
+				t.Errorf("job = %q %q, want not done with %q", job.Status, job.ErrorCode, transcoder.ErrorCodeVerificationFailed)
+			}
+		})
+	}
+}
+
+func TestVerifyOutputDefault(t *testing.T) {
+	preset := transcodertest.NewPreset()
+	preset.Verify = &transcoder.Verification{NonEmpty: true}
+	params := transcoder.JobParams{"input": "in.mov", "output": "out.mp4"}
+
+	// Nothing to check without an output
+	var validationErr *transcoder.ValidationError
+	err := preset.Validate(params)
+	if !errors.As(err, &validationErr) || len(validationErr.Fields) != 1 || validationErr.Fields[0].Field != "verify.output" {
+		t.Errorf("Validate() = %v, want a verify.output error", err)
+	}
+	if _, err := preset.Render(params); !errors.Is(err, transcoder.ErrNoVerifyOutput) {
+		t.Errorf("Render() = %v, want %v", err, transcoder.ErrNoVerifyOutput)
+	}
+
+	// Atomic presets check their output
+	preset.AtomicOutput = "output"
+	if err := preset.Validate(params); err != nil {
+		t.Fatalf("Validate() = %v", err)
+	}
+	command, err := preset.Render(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if command.Verify != "out.mp4" {
+		t.Errorf("Verify = %q, want the atomic output", command.Verify)
+	}
+
+	// The duration can't be compared without probing the input
+	preset.Verify.DurationTolerance = 0.5
+	err = preset.Validate(params)
+	if !errors.As(err, &validationErr) || len(validationErr.Fields) != 1 || validationErr.Fields[0].Field != "verify.durationTolerance" {
+		t.Errorf("Validate() = %v, want a verify.durationTolerance error", err)
+	}
+	if _, err := preset.Render(params); !errors.Is(err, transcoder.ErrNoProbeInput) {
+		t.Errorf("Render() = %v, want %v", err, transcoder.ErrNoProbeInput)
+	}
+	preset.ProbeInput = "input"
+	if err := preset.Validate(params); err != nil {
+		t.Errorf("Validate() = %v with a ProbeInput", err)
+	}
+}
+
+func TestRenderPlaceholders(t *testing.T) {
+	params := transcoder.JobParams{"input": "/in/Clip.MOV"}
+	tests := []struct {
+		template string
+		want     string
+		wantErr  bool
+	}{
+		{template: "{{input|basename|lower}}", want: "clip.mov"},
+		{template: "{{width|1280}}", want: "1280"},
+		{template: `{{codec|"h264"}}`, want: "h264"},
+		{template: `{{case|"lower"|lower}}`, want: "lower"},
+		{template: "{{name|}}", want: ""},
+		{template: "{{input|basname}}", wantErr: true},
+		{template: "{{codec|h264}}", wantErr: true},
+		{template: "{{width|1280|720}}", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := transcoder.Render(tt.template, params)
+		var templateErr *transcoder.TemplateError
+		if tt.wantErr {
+			if !errors.As(err, &templateErr) {
+				t.Errorf("Render(%q) = %q, %v, want a TemplateError", tt.template, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("Render(%q) = %q, %v, want %q", tt.template, got, err, tt.want)
+		}
+	}
+
+	// Presets with a misspelled filter fail validation instead of rendering it as a default
+	preset := transcodertest.NewPreset()
+	preset.Args = append(preset.Args, "-metadata", "title={{input|basname}}")
+	var validationErr *transcoder.ValidationError
+	err := preset.Validate(transcoder.JobParams{"input": "in.mov", "output": "out.mp4"})
+	if !errors.As(err, &validationErr) || len(validationErr.Fields) != 1 || validationErr.Fields[0].Field != "input" {
+		t.Errorf("Validate() = %v, want an input field error", err)
+	}
+}
+
+func TestJobRender(t *testing.T) {
+	preset := &transcoder.Preset{
+		Path: "ffmpeg",
+		Args: []string{"-i", "{{input}}", "-vf", "scale={{width|1280}}:-2", "-metadata", "title={{input|basename|lower}}", "{{output}}.mp4"},
+		Env:  []string{"FFREPORT=file={{output|dir}}/report.log"},
+	}
+	command, err := preset.Render(transcoder.JobParams{"input": "/in/Clip.MOV", "output": "/out/clip"})
+	if err != nil {
+		t.Fatalf("Render() = %v", err)
+	}
+	want := "FFREPORT=file=/out/report.log ffmpeg -i /in/Clip.MOV -vf scale=1280:-2 -metadata title=clip.mov /out/clip.mp4"
+	if got := command.String(); got != want {
+		t.Errorf("Render() = %q, want %q", got, want)
+	}
+
+	// Only env values are quoted so the shell still reads them as assignments
+	command = &transcoder.Command{Path: "ffmpeg", Env: []string{"TITLE=my clip", "EMPTY=", "QUOTE=it's"}, Args: []string{"-version"}}
+	want = `TITLE='my clip' EMPTY='' QUOTE='it'\''s' ffmpeg -version`
+	if got := command.String(); got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+
+	_, err = preset.Render(transcoder.JobParams{"input": "in.mov"})
+	var missing *transcoder.MissingParamError
+	if !errors.As(err, &missing) || strings.Join(missing.Names, ",") != "output" {
+		t.Errorf("Render() = %v, want missing output", err)
+	}
+}
+
+func TestNewJobValidation(t *testing.T) {
+	preset := transcodertest.NewPreset()
+	preset.Params = append(preset.Params,
+		transcoder.ParamSpec{Name: "crf", Type: transcoder.ParamTypeInt},
+		transcoder.ParamSpec{Name: "speed", Type: transcoder.ParamTypeEnum, Values: []string{"fast", "slow"}},
+	)
+
+	_, err := transcoder.NewJob(preset, transcoder.JobParams{"input": "in.mov", "crf": "high", "speed": "medium", "extra": "x"})
+	var validationErr *transcoder.ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("NewJob() = %v, want ValidationError", err)
+	}
+	fields := map[string]bool{}
+	for _, f := range validationErr.Fields {
+		fields[f.Field] = true
+	}
+	for _, want := range []string{"output", "crf", "speed", "extra"} {
+		if !fields[want] {
+			t.Errorf("missing field error for %q in %v", want, validationErr.Fields)
+		}
+	}
+
+	if _, err := transcoder.NewJob(preset, transcoder.JobParams{"input": "in.mov", "output": "out.mp4", "crf": "23"}); err != nil {
+		t.Errorf("NewJob() = %v, want nil", err)
+	}
+}
+
+// waitFor - poll cond until it is true or the test times out
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,20 @@
+package transcoder
+
+import "context"
+
+// Handler - runs a job, the innermost Handler runs a single attempt
+// Failed attempts are retried with the whole chain according to the job RetryPolicy
+// ctx is canceled when the Pool stops running jobs
+type Handler func(ctx context.Context, job *Job) error
+
+// Middleware - wraps a Handler to add behaviour around every attempt of a job
+// Call next to continue the chain, return an error without calling it to fail the job
+type Middleware func(next Handler) Handler
+
+// chain - wrap handler so the first middleware runs outermost
+func chain(handler Handler, middleware []Middleware) Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	return handler
+}
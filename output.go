@@ -0,0 +1,184 @@
+package transcoder
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// DefaultOutputLines - lines of stdout and stderr kept in memory when Job.OutputLines is zero
+var DefaultOutputLines = 1000
+
+// LogLine - single line of process output
+type LogLine struct {
+	N      int    `json:"n"` // 0-based position in the full log
+	Stream string `json:"stream"`
+	Text   string `json:"text"`
+}
+
+// lineBuffer - ring buffer keeping the last limit lines
+type lineBuffer struct {
+	limit int
+	lines []string
+	next  int // index of the oldest line once full
+	total int // lines ever added
+}
+
+func newLineBuffer(limit int) *lineBuffer {
+	if limit < 1 {
+		limit = 1
+	}
+	return &lineBuffer{limit: limit}
+}
+
+func (b *lineBuffer) add(line string) {
+	b.total++
+	if len(b.lines) < b.limit {
+		b.lines = append(b.lines, line)
+		return
+	}
+	b.lines[b.next] = line
+	b.next = (b.next + 1) % b.limit
+}
+
+// all - retained lines oldest first
+func (b *lineBuffer) all() []string {
+	if b == nil {
+		return nil
+	}
+	out := make([]string, 0, len(b.lines))
+	out = append(out, b.lines[b.next:]...)
+	return append(out, b.lines[:b.next]...)
+}
+
+func (b *lineBuffer) MarshalJSON() ([]byte, error) {
+	return json.Marshal(b.all())
+}
+
+// outputLines - job retention if set, otherwise DefaultOutputLines
+func (job *Job) outputLines() int {
+	if job.OutputLines > 0 {
+		return job.OutputLines
+	}
+	return DefaultOutputLines
+}
+
+// SetLogWriter - stream every output line to w in addition to LogPath
+// Lines are written as "<stream>: <text>"
+func (job *Job) SetLogWriter(w io.Writer) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	job.logSink = w
+}
+
+// openLog - open LogPath for appending and combine it with any SetLogWriter sink
+func (job *Job) openLog() (io.Closer, error) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	job.logWriter = job.logSink
+	if job.LogPath == "" {
+		return nil, nil
+	}
+	f, err := os.OpenFile(job.LogPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("opening log %w", err)
+	}
+	if job.logWriter != nil {
+		job.logWriter = io.MultiWriter(f, job.logWriter)
+	} else {
+		job.logWriter = f
+	}
+	return f, nil
+}
+
+// closeLog - stop writing to the log file
+func (job *Job) closeLog(closer io.Closer) {
+	job.mu.Lock()
+	job.logWriter = nil
+	job.mu.Unlock()
+	if closer != nil {
+		closer.Close()
+	}
+}
+
+// writeLog - write a line to the log sinks
+// job.mu must be held by the caller
+func (job *Job) writeLog(stream, line string) {
+	if job.logWriter == nil {
+		return
+	}
+	if _, err := fmt.Fprintf(job.logWriter, "%s: %s\n", stream, line); err != nil {
+		// Don't fail the job over a broken log sink
+		job.logWriter = nil
+	}
+}
+
+// Log - page through the complete output starting at line offset
+// Reads LogPath when set, otherwise only the lines retained in memory are available
+// limit <= 0 returns everything after offset
+func (job *Job) Log(offset, limit int) ([]LogLine, error) {
+	if job.LogPath != "" {
+		return readLogPage(job.LogPath, offset, limit)
+	}
+
+	job.mu.RLock()
+	defer job.mu.RUnlock()
+	if job.info == nil {
+		return nil, nil
+	}
+	// Memory only keeps each stream separately, stdout lines are listed first
+	lines := make([]LogLine, 0)
+	for _, l := range job.info.Output.all() {
+		lines = append(lines, LogLine{Stream: StreamStdout, Text: l})
+	}
+	for _, l := range job.info.ErrOutput.all() {
+		lines = append(lines, LogLine{Stream: StreamStderr, Text: l})
+	}
+	for i := range lines {
+		lines[i].N = i
+	}
+	return page(lines, offset, limit), nil
+}
+
+func page(lines []LogLine, offset, limit int) []LogLine {
+	if offset >= len(lines) {
+		return []LogLine{}
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	lines = lines[offset:]
+	if limit > 0 && limit < len(lines) {
+		lines = lines[:limit]
+	}
+	return lines
+}
+
+// readLogPage - read lines written by writeLog
+func readLogPage(path string, offset, limit int) ([]LogLine, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening log %w", err)
+	}
+	defer f.Close()
+
+	lines := make([]LogLine, 0)
+	scanner := bufio.NewScanner(f)
+	for n := 0; scanner.Scan(); n++ {
+		if n < offset {
+			continue
+		}
+		if limit > 0 && len(lines) >= limit {
+			break
+		}
+		line := LogLine{N: n, Text: scanner.Text()}
+		if split := strings.SplitN(line.Text, ": ", 2); len(split) == 2 {
+			line.Stream, line.Text = split[0], split[1]
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
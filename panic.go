@@ -0,0 +1,43 @@
+package transcoder
+
+import (
+	"bufio"
+	"fmt"
+	"runtime/debug"
+)
+
+// PanicError - a panic recovered while running a job
+type PanicError struct {
+	Value interface{}
+	Stack string
+}
+
+func newPanicError(value interface{}) *PanicError {
+	return &PanicError{Value: value, Stack: string(debug.Stack())}
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// recoverReader - deferred by output readers, records a panic and keeps draining so the process can exit
+func (job *Job) recoverReader(scanner *bufio.Scanner) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	job.mu.Lock()
+	if job.panicErr == nil {
+		job.panicErr = newPanicError(r)
+	}
+	job.mu.Unlock()
+	for scanner.Scan() {
+	}
+}
+
+// recovered - panic recovered by an output reader during the last run
+func (job *Job) recovered() *PanicError {
+	job.mu.RLock()
+	defer job.mu.RUnlock()
+	return job.panicErr
+}
@@ -0,0 +1,166 @@
+package transcoder
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ParamTypeString   = "string"
+	ParamTypePath     = "path"
+	ParamTypeInt      = "int"
+	ParamTypeEnum     = "enum"
+	ParamTypeDuration = "duration" // seconds, ffmpeg timecode (00:01:30.5), or Go duration (1m30s)
+)
+
+// ParamSpec - a job param a Preset accepts
+type ParamSpec struct {
+	Name        string   `json:"name"`
+	Type        string   `json:"type,omitempty"` // empty means ParamTypeString
+	Required    bool     `json:"required"`
+	Values      []string `json:"values,omitempty"`  // allowed values for ParamTypeEnum
+	Pattern     string   `json:"pattern,omitempty"` // regexp the whole value must match
+	Description string   `json:"description,omitempty"`
+}
+
+// FieldError - problem with a single job param
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError - every problem found with a set of job params
+type ValidationError struct {
+	Fields []FieldError `json:"fields"`
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		msgs[i] = fmt.Sprintf("%s: %s", f.Field, f.Message)
+	}
+	return fmt.Sprintf("invalid params %s", strings.Join(msgs, "; "))
+}
+
+// Validate - check params against the declared Params and the placeholders in Args
+// Params not declared are rejected when the preset declares any
+func (preset *Preset) Validate(params JobParams) error {
+	var fields []FieldError
+	declared := make(map[string]bool, len(preset.Params))
+	for _, spec := range preset.Params {
+		declared[spec.Name] = true
+		value, ok := params[spec.Name]
+		if !ok {
+			if spec.Required {
+				fields = append(fields, FieldError{Field: spec.Name, Message: "required"})
+			}
+			continue
+		}
+		if err := spec.validate(value); err != nil {
+			fields = append(fields, FieldError{Field: spec.Name, Message: err.Error()})
+		}
+	}
+	if len(preset.Params) > 0 {
+		for name := range params {
+			if !declared[name] {
+				fields = append(fields, FieldError{Field: name, Message: "unknown param"})
+			}
+		}
+	}
+
+	// Placeholders without a default need a value even if the preset doesn't declare them
+	var missing *MissingParamError
+	var templateErr *TemplateError
+	_, err := preset.Render(params)
+	switch {
+	case errors.As(err, &missing):
+		for _, name := range missing.Names {
+			if !declared[name] {
+				fields = append(fields, FieldError{Field: name, Message: "required by preset"})
+			}
+		}
+	case errors.As(err, &templateErr):
+		fields = append(fields, FieldError{Field: templateErr.Name, Message: templateErr.Error()})
+	}
+
+	if preset.Verify != nil && preset.verifyOutput() == "" {
+		fields = append(fields, FieldError{Field: "verify.output", Message: ErrNoVerifyOutput.Error()})
+	}
+	// Otherwise every job would transcode the whole input before failing verification
+	if preset.Verify != nil && preset.Verify.DurationTolerance > 0 && preset.ProbeInput == "" {
+		fields = append(fields, FieldError{Field: "verify.durationTolerance", Message: ErrNoProbeInput.Error()})
+	}
+
+	if len(fields) > 0 {
+		return &ValidationError{Fields: fields}
+	}
+	return nil
+}
+
+// validate - check a single value against the spec
+func (spec ParamSpec) validate(value string) error {
+	switch spec.Type {
+	case "", ParamTypeString:
+	case ParamTypePath:
+		if strings.TrimSpace(value) == "" {
+			return errors.New("empty path")
+		}
+		if strings.ContainsRune(value, 0) {
+			return errors.New("path contains NUL")
+		}
+	case ParamTypeInt:
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+			return fmt.Errorf("not an integer %q", value)
+		}
+	case ParamTypeEnum:
+		if !contains(spec.Values, value) {
+			return fmt.Errorf("must be one of %s", strings.Join(spec.Values, ", "))
+		}
+	case ParamTypeDuration:
+		if _, err := ParseDuration(value); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown param type %q", spec.Type)
+	}
+
+	if spec.Pattern != "" {
+		reg, err := regexp.Compile("^(?:" + spec.Pattern + ")$")
+		if err != nil {
+			return fmt.Errorf("bad pattern %v", err)
+		}
+		if !reg.MatchString(value) {
+			return fmt.Errorf("must match %s", spec.Pattern)
+		}
+	}
+	return nil
+}
+
+// ParseDuration - parse seconds, an ffmpeg timecode, or a Go duration string
+func ParseDuration(value string) (time.Duration, error) {
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds >= 0 {
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+	if strings.Count(value, ":") == 2 {
+		if seconds := parseDurationFromTimecode(value); seconds > 0 || strings.Trim(value, "0:.") == "" {
+			return time.Duration(seconds * float64(time.Second)), nil
+		}
+	}
+	if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+		return d, nil
+	}
+	return 0, fmt.Errorf("not a duration %q", value)
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,488 @@
+package transcoder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrPoolClosed - returned by Submit once Shutdown has been called
+var ErrPoolClosed = errors.New("pool is shut down")
+
+// ErrJobQueued - returned by Submit for a job that is already queued or waiting for a retry
+var ErrJobQueued = errors.New("job is already queued")
+
+// MaxBypass - queued jobs that may start ahead of the first queued job while it doesn't fit the capacity
+// Once reached nothing else starts until running jobs free enough for it, so heavy jobs can't starve
+var MaxBypass = 2
+
+// Pool - a set of Workers running submitted jobs
+type Pool struct {
+	Classifier Classifier // used for jobs without their own Classifier
+	Executor   Executor   // used for jobs without their own Executor
+
+	mu         sync.Mutex
+	cond       *sync.Cond
+	pending    []*Job        // highest Priority first, then submission order
+	announcing map[*Job]bool // pending jobs whose queued event is still being published, not taken until it is
+	closed     bool
+	canceled   bool      // pending jobs were canceled by Shutdown
+	workers    []*Worker // includes retiring workers until they exit
+	size       int       // workers accepting jobs
+	capacity   Cost      // budget shared by running jobs, zero fields are unlimited
+	used       Cost      // held by running jobs
+	started    int       // workers ever started, used for names
+	restarts   int       // workers replaced after a panic
+	middleware []Middleware
+	blocked    *Job // first pending job while it doesn't fit the capacity
+	bypassed   int  // jobs started ahead of blocked
+	startedAt  time.Time
+	wg         sync.WaitGroup
+	ctx        context.Context
+	cancel     context.CancelFunc
+	events     *EventBus
+}
+
+// PoolStats - snapshot of a Pool and its workers
+type PoolStats struct {
+	Size      int           `json:"size"`   // workers accepting jobs
+	Queued    int           `json:"queued"` // jobs waiting for a worker
+	Busy      int           `json:"busy"`
+	Capacity  Cost          `json:"capacity"`
+	Used      Cost          `json:"used"`     // held by running jobs
+	Restarts  int           `json:"restarts"` // workers replaced after a panic
+	StartedAt time.Time     `json:"startedAt"`
+	Workers   []WorkerStats `json:"workers"`
+}
+
+// NewPool create a pool and start workerNum workers
+func NewPool(workerNum int, events *EventBus) *Pool {
+	ctx, cancel := context.WithCancel(context.Background())
+	pool := &Pool{
+		announcing: map[*Job]bool{},
+		startedAt:  time.Now(),
+		ctx:        ctx,
+		cancel:     cancel,
+		events:     events,
+	}
+	pool.cond = sync.NewCond(&pool.mu)
+
+	pool.mu.Lock()
+	pool.resize(workerNum)
+	pool.mu.Unlock()
+	return pool
+}
+
+// Resize - start or stop workers until size accept jobs
+// Stopped workers finish their current job first, idle ones are stopped before busy ones
+func (pool *Pool) Resize(size int) error {
+	if size < 0 {
+		return fmt.Errorf("pool size %d must not be negative", size)
+	}
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	if pool.closed {
+		return ErrPoolClosed
+	}
+	pool.resize(size)
+	return nil
+}
+
+// resize - caller holds mu
+func (pool *Pool) resize(size int) {
+	for ; pool.size < size; pool.size++ {
+		pool.startWorker()
+	}
+	if pool.size == size {
+		return
+	}
+
+	var idle, busy []*Worker
+	for _, worker := range pool.workers {
+		switch {
+		case worker.isRetiring():
+		case worker.isBusy():
+			busy = append(busy, worker)
+		default:
+			idle = append(idle, worker)
+		}
+	}
+	for _, worker := range append(idle, busy...)[:pool.size-size] {
+		worker.retire()
+	}
+	pool.size = size
+	pool.cond.Broadcast()
+}
+
+// startWorker - caller holds mu
+func (pool *Pool) startWorker() {
+	worker := newWorker(pool.ctx, nil, pool.events)
+	worker.next = func() (*Job, bool) { return pool.next(worker) }
+	worker.requeue = pool.requeue
+	worker.Name = fmt.Sprintf("Worker%d", pool.started)
+	pool.started++
+	pool.workers = append(pool.workers, worker)
+	pool.wg.Add(1)
+	go func() {
+		defer pool.wg.Done()
+		if worker.start() {
+			pool.removeWorker(worker)
+			return
+		}
+		pool.replaceWorker(worker)
+	}()
+}
+
+func (pool *Pool) removeWorker(worker *Worker) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	pool.remove(worker)
+}
+
+// replaceWorker - start a new worker in place of one that recovered a panic
+func (pool *Pool) replaceWorker(worker *Worker) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	pool.remove(worker)
+	pool.restarts++
+	if !worker.isRetiring() {
+		pool.startWorker()
+	}
+	log.Printf("%s replaced after a panic", worker.Name)
+}
+
+// remove - drop worker and release the cost of its last job, caller holds mu
+func (pool *Pool) remove(worker *Worker) {
+	if !worker.cost.isZero() {
+		pool.used = pool.used.sub(worker.cost)
+		worker.cost = Cost{}
+		pool.cond.Broadcast()
+	}
+	for i, w := range pool.workers {
+		if w == worker {
+			pool.workers = append(pool.workers[:i], pool.workers[i+1:]...)
+			return
+		}
+	}
+}
+
+// Stats - current size, queue length and state of every worker
+func (pool *Pool) Stats() PoolStats {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	stats := PoolStats{
+		Size:      pool.size,
+		Queued:    len(pool.pending),
+		Capacity:  pool.capacity,
+		Used:      pool.used,
+		Restarts:  pool.restarts,
+		StartedAt: pool.startedAt,
+		Workers:   make([]WorkerStats, 0, len(pool.workers)),
+	}
+	for _, worker := range pool.workers {
+		workerStats := worker.Stats()
+		if workerStats.JobID != nil {
+			stats.Busy++
+		}
+		stats.Workers = append(stats.Workers, workerStats)
+	}
+	return stats
+}
+
+// Submit - queue job for the next free worker, ahead of queued jobs with a lower Priority
+func (pool *Pool) Submit(job *Job) error {
+	pool.mu.Lock()
+	if pool.closed {
+		pool.mu.Unlock()
+		return ErrPoolClosed
+	}
+	if pool.isPending(job) {
+		pool.mu.Unlock()
+		return ErrJobQueued
+	}
+	job.retryAt = time.Time{}
+	pool.insertPending(job)
+	pool.announcing[job] = true
+	pool.mu.Unlock()
+
+	// Outside mu so a blocking subscriber can't stall the pool,
+	// no worker takes the job until it's published so queued always comes before started
+	publishStatus(pool.events, job, JobEvent{Type: JobEventQueued})
+
+	pool.mu.Lock()
+	delete(pool.announcing, job)
+	canceled := pool.canceled
+	if canceled {
+		pool.removePending(job)
+	}
+	pool.cond.Broadcast()
+	pool.mu.Unlock()
+	if canceled {
+		pool.cancelJob(job)
+	}
+	return nil
+}
+
+// insertPending - queue job behind others of the same priority, caller holds mu
+func (pool *Pool) insertPending(job *Job) {
+	i := sort.Search(len(pool.pending), func(i int) bool {
+		return pool.pending[i].Priority < job.Priority
+	})
+	pool.pending = append(pool.pending, nil)
+	copy(pool.pending[i+1:], pool.pending[i:])
+	pool.pending[i] = job
+}
+
+// requeue - queue job again after a failed attempt, workers don't take it until wait has passed
+// ErrPoolClosed once Shutdown has canceled the queued jobs
+func (pool *Pool) requeue(job *Job, wait time.Duration) error {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	if pool.canceled {
+		return ErrPoolClosed
+	}
+	job.retryAt = time.Now().Add(wait)
+	pool.insertPending(job)
+	job.setCancelRetry(func() bool { return pool.killRetrying(job) })
+	time.AfterFunc(wait, func() {
+		pool.mu.Lock()
+		pool.cond.Broadcast()
+		pool.mu.Unlock()
+	})
+	return nil
+}
+
+// SetCapacity - budget shared by running jobs, each holds its Preset Cost
+// Queued jobs start in priority order, up to MaxBypass skip ahead of one that doesn't fit until running jobs finish
+func (pool *Pool) SetCapacity(capacity Cost) {
+	pool.mu.Lock()
+	pool.capacity = capacity
+	pool.cond.Broadcast()
+	pool.mu.Unlock()
+}
+
+// SetExecutor - Executor for jobs taken from now on that have none, safe while workers run
+func (pool *Pool) SetExecutor(executor Executor) {
+	pool.mu.Lock()
+	pool.Executor = executor
+	pool.mu.Unlock()
+}
+
+// Use - wrap jobs started from now on with middleware, the first added runs outermost
+func (pool *Pool) Use(middleware ...Middleware) {
+	pool.mu.Lock()
+	// Copy so workers keep the chain they started their job with
+	pool.middleware = append(append([]Middleware{}, pool.middleware...), middleware...)
+	pool.mu.Unlock()
+}
+
+// Shutdown - stop accepting jobs and wait for queued and running jobs to finish
+// When ctx is done first, running jobs are stopped with their StopPolicy, queued jobs are canceled
+// and ctx.Err() is returned once every worker has exited. That waits out the GracePeriod of stopped
+// processes and any Always post hooks, use ShutdownWithin to bound it
+func (pool *Pool) Shutdown(ctx context.Context) error {
+	pool.mu.Lock()
+	pool.closed = true
+	pool.cond.Broadcast()
+	pool.mu.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		pool.wg.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+	}
+
+	pool.cancelPending()
+	pool.cancel()
+	<-finished
+	return ctx.Err()
+}
+
+// ShutdownWithin - Shutdown that is over by the ctx deadline, e.g. the termination window of the process
+// Running jobs are stopped early enough that the longest GracePeriod of a running or queued job still fits,
+// so their processes exit before the window ends. Returns ctx.Err() at the deadline if workers are still running
+func (pool *Pool) ShutdownWithin(ctx context.Context) error {
+	drainCtx := ctx
+	if deadline, ok := ctx.Deadline(); ok {
+		var cancel context.CancelFunc
+		drainCtx, cancel = context.WithDeadline(ctx, deadline.Add(-pool.maxGracePeriod()))
+		defer cancel()
+	}
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- pool.Shutdown(drainCtx) }()
+	select {
+	case err := <-shutdown:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("workers still running: %w", ctx.Err())
+	}
+}
+
+// maxGracePeriod - longest time a running or queued job may take to stop
+func (pool *Pool) maxGracePeriod() time.Duration {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	jobs := append([]*Job{}, pool.pending...)
+	for _, worker := range pool.workers {
+		if job := worker.currentJob(); job != nil {
+			jobs = append(jobs, job)
+		}
+	}
+
+	var longest time.Duration
+	for _, job := range jobs {
+		// Killed right away without a grace period
+		if policy := job.stopPolicy(); policy.Method != StopKill && policy.GracePeriod > longest {
+			longest = policy.GracePeriod
+		}
+	}
+	return longest
+}
+
+// next - release the previous job of worker and block until there is one that fits the capacity
+// false once the worker is retired, or the pool is shut down and drained
+func (pool *Pool) next(worker *Worker) (*Job, bool) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	if !worker.cost.isZero() {
+		pool.used = pool.used.sub(worker.cost)
+		worker.cost = Cost{}
+		pool.cond.Broadcast()
+	}
+
+	var job *Job
+	for job == nil {
+		if worker.isRetiring() {
+			return nil, false
+		}
+		if job = pool.take(); job != nil {
+			break
+		}
+		if pool.closed && len(pool.pending) == 0 {
+			return nil, false
+		}
+		pool.cond.Wait()
+	}
+
+	worker.cost = job.cost()
+	pool.used = pool.used.add(worker.cost)
+	if job.Classifier == nil {
+		job.Classifier = pool.Classifier
+	}
+	if job.Executor == nil {
+		job.Executor = pool.Executor
+	}
+	worker.Middleware = pool.middleware
+	return job, true
+}
+
+// take - remove the first pending job that fits, caller holds mu
+// Later jobs only pass a first job that doesn't fit MaxBypass times, then the pool waits for it
+func (pool *Pool) take() *Job {
+	now := time.Now()
+	var head *Job
+	for i, job := range pool.pending {
+		// Not queued yet, or waiting for a retry
+		if pool.announcing[job] || job.retryAt.After(now) {
+			continue
+		}
+		fits := pool.capacity.admits(pool.used, job.cost())
+		if head == nil {
+			head = job
+			if fits {
+				pool.pending = append(pool.pending[:i], pool.pending[i+1:]...)
+				pool.blocked, pool.bypassed = nil, 0
+				return job
+			}
+			if pool.blocked != head {
+				pool.blocked, pool.bypassed = head, 0
+			}
+			if pool.bypassed >= MaxBypass {
+				return nil
+			}
+			continue
+		}
+		if fits {
+			pool.pending = append(pool.pending[:i], pool.pending[i+1:]...)
+			pool.bypassed++
+			return job
+		}
+	}
+	return nil
+}
+
+// killRetrying - fail a job waiting for a retry, false if a worker has already taken it
+func (pool *Pool) killRetrying(job *Job) bool {
+	pool.mu.Lock()
+	removed := pool.removePending(job)
+	pool.mu.Unlock()
+	if !removed {
+		return false
+	}
+	killRetry(job)
+	rejectJob(pool.events, job, JobEvent{Message: "killed before retry"})
+	return true
+}
+
+// isPending - caller holds mu
+func (pool *Pool) isPending(job *Job) bool {
+	for _, pending := range pool.pending {
+		if pending == job {
+			return true
+		}
+	}
+	return false
+}
+
+// removePending - false if job isn't pending, caller holds mu
+func (pool *Pool) removePending(job *Job) bool {
+	for i, pending := range pool.pending {
+		if pending == job {
+			pool.pending = append(pool.pending[:i], pool.pending[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// cancelPending - release jobs that never reached a worker
+// Jobs still being announced are left for Submit to cancel after their queued event
+func (pool *Pool) cancelPending() {
+	pool.mu.Lock()
+	pool.canceled = true
+	var pending, announcing []*Job
+	for _, job := range pool.pending {
+		if pool.announcing[job] {
+			announcing = append(announcing, job)
+		} else {
+			pending = append(pending, job)
+		}
+	}
+	pool.pending = announcing
+	pool.mu.Unlock()
+
+	for _, job := range pending {
+		pool.cancelJob(job)
+	}
+}
+
+// cancelJob - mark a job that never reached a worker canceled
+func (pool *Pool) cancelJob(job *Job) {
+	job.mu.Lock()
+	job.err = fmt.Errorf("job %v not started: %w", job.ID, ErrPoolClosed)
+	job.Status = JobStatusCanceled
+	job.ErrorCode = ErrorCodeCanceled
+	job.mu.Unlock()
+	rejectJob(pool.events, job, JobEvent{Message: "pool shut down before job started"})
+}
@@ -0,0 +1,583 @@
+package transcoder_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/palmdalian/transcoder"
+	"github.com/palmdalian/transcoder/transcodertest"
+)
+
+func TestPoolShutdownDrains(t *testing.T) {
+	pool := transcoder.NewPool(1, nil)
+	pool.Executor = transcodertest.NewExecutor(transcodertest.Progress)
+
+	jobs := []*transcoder.Job{transcodertest.NewJob(nil), transcodertest.NewJob(nil)}
+	for _, job := range jobs {
+		if err := pool.Submit(job); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := pool.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() = %v", err)
+	}
+	for _, job := range jobs {
+		if job.Status != transcoder.JobStatusDone {
+			t.Errorf("Status = %q, want %q", job.Status, transcoder.JobStatusDone)
+		}
+	}
+	if err := pool.Submit(transcodertest.NewJob(nil)); !errors.Is(err, transcoder.ErrPoolClosed) {
+		t.Errorf("Submit() after Shutdown = %v, want %v", err, transcoder.ErrPoolClosed)
+	}
+}
+
+func TestPoolShutdownDeadline(t *testing.T) {
+	events := transcoder.NewEventBus()
+	started, unsubscribe := events.Subscribe(transcoder.SubscribeOptions{Types: []string{transcoder.JobEventStarted}})
+	defer unsubscribe()
+	pool := transcoder.NewPool(1, events)
+	pool.Executor = transcodertest.NewExecutor(transcodertest.Script{Hang: true})
+
+	running := transcodertest.NewJob(nil)
+	queued := transcodertest.NewJob(nil)
+	for _, job := range []*transcoder.Job{running, queued} {
+		if err := pool.Submit(job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitForStarted(t, started, running)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := pool.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Shutdown() = %v, want deadline exceeded", err)
+	}
+
+	for name, job := range map[string]*transcoder.Job{"running": running, "queued": queued} {
+		job.Wait()
+		if job.Status != transcoder.JobStatusCanceled {
+			t.Errorf("%s Status = %q, want %q", name, job.Status, transcoder.JobStatusCanceled)
+		}
+		if job.ErrorCode != transcoder.ErrorCodeCanceled {
+			t.Errorf("%s ErrorCode = %q, want %q", name, job.ErrorCode, transcoder.ErrorCodeCanceled)
+		}
+	}
+	if !errors.Is(queued.Err(), transcoder.ErrPoolClosed) {
+		t.Errorf("queued Err() = %v, want %v", queued.Err(), transcoder.ErrPoolClosed)
+	}
+}
+
+func TestPoolShutdownWithin(t *testing.T) {
+	executor := transcodertest.NewExecutor(transcodertest.Script{Hang: true})
+	executor.Script = func(cmd *transcoder.Command) transcodertest.Script {
+		if cmd.Path == "cleanup" {
+			// Only SIGKILL stops it, and nothing sends one to an Always hook after shutdown
+			return transcodertest.Script{Hang: true, IgnoreInterrupt: true}
+		}
+		return transcodertest.Script{Hang: true}
+	}
+	newPool := func(post []transcoder.Hook) (*transcoder.Pool, *transcoder.Job) {
+		pool := transcoder.NewPool(1, nil)
+		pool.Executor = executor
+		job := transcodertest.NewJob(nil)
+		job.Preset.Post = post
+		job.Preset.Stop = &transcoder.StopPolicy{Method: transcoder.StopQuit, GracePeriod: 800 * time.Millisecond}
+		if err := pool.Submit(job); err != nil {
+			t.Fatal(err)
+		}
+		waitFor(t, func() bool { return pool.Stats().Busy == 1 })
+		return pool, job
+	}
+
+	// Stopped as soon as the grace period still fits in the window
+	pool, job := newPool(nil)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	started := time.Now()
+	if err := pool.ShutdownWithin(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("ShutdownWithin() = %v, want deadline exceeded", err)
+	}
+	if elapsed := time.Since(started); elapsed > 800*time.Millisecond {
+		t.Errorf("ShutdownWithin() took %v, want the job stopped 800ms before the window ends", elapsed)
+	}
+	if job.Status != transcoder.JobStatusCanceled {
+		t.Errorf("Status = %q, want %q", job.Status, transcoder.JobStatusCanceled)
+	}
+
+	// A hanging cleanup hook doesn't hold it past the window
+	pool, _ = newPool([]transcoder.Hook{{Path: "cleanup", Always: true}})
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	started = time.Now()
+	if err := pool.ShutdownWithin(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("ShutdownWithin() = %v, want deadline exceeded", err)
+	}
+	if elapsed := time.Since(started); elapsed > 1500*time.Millisecond {
+		t.Errorf("ShutdownWithin() took %v, want it to return at the end of the window", elapsed)
+	}
+}
+
+func TestPoolResize(t *testing.T) {
+	events := transcoder.NewEventBus()
+	started, unsubscribe := events.Subscribe(transcoder.SubscribeOptions{Types: []string{transcoder.JobEventStarted}})
+	defer unsubscribe()
+	pool := transcoder.NewPool(1, events)
+	pool.Executor = transcodertest.NewExecutor(transcodertest.Script{Hang: true})
+	defer pool.Shutdown(context.Background())
+
+	running := transcodertest.NewJob(nil)
+	if err := pool.Submit(running); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return pool.Stats().Busy == 1 })
+
+	if err := pool.Resize(3); err != nil {
+		t.Fatal(err)
+	}
+	if stats := pool.Stats(); stats.Size != 3 || len(stats.Workers) != 3 {
+		t.Errorf("Size = %d with %d workers, want 3", stats.Size, len(stats.Workers))
+	}
+
+	// Idle workers exit right away, the busy one once its job ends
+	if err := pool.Resize(0); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return len(pool.Stats().Workers) == 1 })
+	stats := pool.Stats()
+	if w := stats.Workers[0]; w.State != transcoder.WorkerStopping || w.JobID == nil || *w.JobID != running.ID {
+		t.Errorf("worker = %+v, want %s running %v", w, transcoder.WorkerStopping, running.ID)
+	}
+
+	queued := transcodertest.NewJob(nil)
+	if err := pool.Submit(queued); err != nil {
+		t.Fatal(err)
+	}
+	if err := running.Kill(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return len(pool.Stats().Workers) == 0 })
+	if stats := pool.Stats(); stats.Queued != 1 {
+		t.Errorf("Queued = %d, want 1", stats.Queued)
+	}
+
+	if err := pool.Resize(1); err != nil {
+		t.Fatal(err)
+	}
+	waitForStarted(t, started, queued)
+	if err := pool.Resize(-1); err == nil {
+		t.Error("Resize(-1) = nil, want error")
+	}
+	queued.Kill()
+}
+
+func TestPoolCapacity(t *testing.T) {
+	events := transcoder.NewEventBus()
+	started, unsubscribe := events.Subscribe(transcoder.SubscribeOptions{Types: []string{transcoder.JobEventStarted}})
+	defer unsubscribe()
+	pool := transcoder.NewPool(3, events)
+	pool.Executor = transcodertest.NewExecutor(transcodertest.Script{Hang: true})
+	pool.SetCapacity(transcoder.Cost{Slots: 4})
+	defer pool.Shutdown(context.Background())
+
+	encode := transcodertest.NewJob(nil)
+	encode.Preset.Cost = &transcoder.Cost{Slots: 3}
+	copies := []*transcoder.Job{transcodertest.NewJob(nil), transcodertest.NewJob(nil)}
+	for _, job := range append([]*transcoder.Job{encode}, copies...) {
+		if err := pool.Submit(job); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// One copy fits next to the encode, the other waits although a worker is free
+	waitFor(t, func() bool { return pool.Stats().Busy == 2 })
+	time.Sleep(20 * time.Millisecond)
+	stats := pool.Stats()
+	if stats.Busy != 2 || stats.Queued != 1 || stats.Used.Slots != 4 {
+		t.Errorf("Busy = %d, Queued = %d, Used = %+v, want 2, 1 and 4 slots", stats.Busy, stats.Queued, stats.Used)
+	}
+	for _, w := range stats.Workers {
+		if w.JobID != nil && *w.JobID == copies[1].ID {
+			t.Errorf("second copy is run by %s, want it queued", w.Name)
+		}
+	}
+
+	if err := encode.Kill(); err != nil {
+		t.Fatal(err)
+	}
+	waitForStarted(t, started, copies[1])
+	if used := pool.Stats().Used; used.Slots != 2 {
+		t.Errorf("Used = %+v, want 2 slots", used)
+	}
+	for _, job := range copies {
+		job.Kill()
+	}
+}
+
+func TestPoolCapacityNoStarvation(t *testing.T) {
+	pool := transcoder.NewPool(4, nil)
+	pool.Executor = transcodertest.NewExecutor(transcodertest.Progress)
+	pool.SetCapacity(transcoder.Cost{Slots: 3})
+	defer pool.Shutdown(context.Background())
+
+	// Keep 1 slot copies queued so there's always one that fits next to the running ones
+	stop := make(chan struct{})
+	fed := make(chan struct{})
+	go func() {
+		defer close(fed)
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(2 * time.Millisecond):
+			}
+			if pool.Stats().Queued < 3 {
+				if err := pool.Submit(transcodertest.NewJob(nil)); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}
+	}()
+	defer func() {
+		close(stop)
+		<-fed
+	}()
+
+	time.Sleep(30 * time.Millisecond)
+	encode := transcodertest.NewJob(nil)
+	encode.Preset.Cost = &transcoder.Cost{Slots: 3}
+	if err := pool.Submit(encode); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-encode.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("3 slot job never started next to a stream of 1 slot jobs")
+	}
+}
+
+func TestPoolSubmitBlockedSubscriber(t *testing.T) {
+	events := transcoder.NewEventBus()
+	queued, unsubscribe := events.Subscribe(transcoder.SubscribeOptions{
+		Buffer:   1,
+		Overflow: transcoder.OverflowBlock,
+		Types:    []string{transcoder.JobEventQueued},
+	})
+	defer unsubscribe()
+	pool := transcoder.NewPool(1, events)
+	pool.Executor = transcodertest.NewExecutor(transcodertest.Progress)
+
+	first, second := transcodertest.NewJob(nil), transcodertest.NewJob(nil)
+	if err := pool.Submit(first); err != nil {
+		t.Fatal(err)
+	}
+	submitted := make(chan error, 1)
+	go func() { submitted <- pool.Submit(second) }()
+
+	// The full subscriber holds up the second Submit but not the pool
+	deadline := time.Now().Add(5 * time.Second)
+	for pool.Stats().Queued != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("second job never queued")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case <-first.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("first job didn't finish while a subscriber was blocked")
+	}
+	if stats := pool.Stats(); stats.Queued != 1 {
+		t.Errorf("Queued = %d, want the unannounced job left queued", stats.Queued)
+	}
+	select {
+	case err := <-submitted:
+		t.Fatalf("Submit() = %v before its queued event was read", err)
+	default:
+	}
+
+	for _, job := range []*transcoder.Job{first, second} {
+		if event := <-queued; event.JobID != job.ID {
+			t.Errorf("queued event for %v, want %v", event.JobID, job.ID)
+		}
+	}
+	if err := <-submitted; err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() = %v", err)
+	}
+	if second.Status != transcoder.JobStatusDone {
+		t.Errorf("Status = %q, want %q", second.Status, transcoder.JobStatusDone)
+	}
+}
+
+func TestPoolRetryBackoff(t *testing.T) {
+	events := transcoder.NewEventBus()
+	started, unsubscribe := events.Subscribe(transcoder.SubscribeOptions{Types: []string{transcoder.JobEventStarted}})
+	defer unsubscribe()
+	pool := transcoder.NewPool(1, events)
+	executor := transcodertest.NewExecutor(transcodertest.Progress)
+	var mu sync.Mutex
+	failed := false
+	executor.Script = func(cmd *transcoder.Command) transcodertest.Script {
+		mu.Lock()
+		defer mu.Unlock()
+		if strings.Contains(cmd.String(), "flaky.mov") && !failed {
+			failed = true
+			return transcodertest.Script{Stderr: []string{"No space left on device"}, ExitCode: 1}
+		}
+		return transcodertest.Progress
+	}
+	pool.Executor = executor
+	defer pool.Shutdown(context.Background())
+
+	flaky := transcodertest.NewJob(nil)
+	flaky.Params["input"] = "flaky.mov"
+	flaky.Retry = &transcoder.RetryPolicy{MaxAttempts: 2, Backoff: 300 * time.Millisecond, TransientOnly: true}
+	if err := pool.Submit(flaky); err != nil {
+		t.Fatal(err)
+	}
+	waitForStarted(t, started, flaky)
+
+	// The only worker runs the next job while the flaky one backs off
+	other := transcodertest.NewJob(nil)
+	if err := pool.Submit(other); err != nil {
+		t.Fatal(err)
+	}
+	waitForStarted(t, started, other)
+	select {
+	case <-other.Done():
+	case <-flaky.Done():
+		t.Fatal("flaky job finished before the job submitted during its backoff")
+	}
+	waitForStarted(t, started, flaky)
+	flaky.Wait()
+
+	if flaky.Status != transcoder.JobStatusDone || len(flaky.Attempts) != 2 {
+		t.Errorf("Status = %q after %d attempts, want %q after 2", flaky.Status, len(flaky.Attempts), transcoder.JobStatusDone)
+	}
+	// The requeued attempt counts as neither
+	if failed := pool.Stats().Workers[0].JobsFailed; failed != 0 {
+		t.Errorf("worker failed %d jobs, want 0", failed)
+	}
+}
+
+func TestPoolKillRetrying(t *testing.T) {
+	events := transcoder.NewEventBus()
+	updates, unsubscribe := events.Subscribe(transcoder.SubscribeOptions{Types: []string{transcoder.JobEventRetried, transcoder.JobEventKilled}})
+	defer unsubscribe()
+	pool := transcoder.NewPool(1, events)
+	pool.Executor = transcodertest.NewExecutor(transcodertest.Script{Stderr: []string{"No space left on device", "Conversion failed!"}, ExitCode: 1})
+	defer pool.Shutdown(context.Background())
+
+	job := transcodertest.NewJob(nil)
+	job.Retry = &transcoder.RetryPolicy{MaxAttempts: 3, Backoff: time.Minute, TransientOnly: true}
+	if err := pool.Submit(job); err != nil {
+		t.Fatal(err)
+	}
+	if event := <-updates; event.Type != transcoder.JobEventRetried {
+		t.Fatalf("got %q event, want %q", event.Type, transcoder.JobEventRetried)
+	}
+
+	// Still queued for its retry
+	if err := pool.Submit(job); !errors.Is(err, transcoder.ErrJobQueued) {
+		t.Errorf("Submit() = %v during backoff, want ErrJobQueued", err)
+	}
+	if queued := pool.Stats().Queued; queued != 1 {
+		t.Errorf("Queued = %d, want 1", queued)
+	}
+
+	if err := job.Kill(); err != nil {
+		t.Fatalf("Kill() = %v during backoff", err)
+	}
+	select {
+	case <-job.Done():
+	case <-time.After(time.Second):
+		t.Fatal("job not done after Kill")
+	}
+	if event := <-updates; event.Type != transcoder.JobEventKilled {
+		t.Errorf("got %q event, want %q", event.Type, transcoder.JobEventKilled)
+	}
+	if job.Status != transcoder.JobStatusFailed || job.ErrorCode != transcoder.ErrorCodeKilled {
+		t.Errorf("Status = %q, ErrorCode = %q, want %q and %q", job.Status, job.ErrorCode, transcoder.JobStatusFailed, transcoder.ErrorCodeKilled)
+	}
+	if len(job.Attempts) != 1 || pool.Stats().Queued != 0 {
+		t.Errorf("got %d attempts and %d queued, want 1 and 0", len(job.Attempts), pool.Stats().Queued)
+	}
+}
+
+func TestPoolPriority(t *testing.T) {
+	executor := transcodertest.NewExecutor(transcodertest.Progress)
+	pool := transcoder.NewPool(0, nil)
+	pool.Executor = executor
+
+	// Queued while there are no workers so the order is decided by priority alone
+	for _, job := range []struct {
+		input    string
+		priority int
+	}{{"backfill.mov", -1}, {"first.mov", 0}, {"urgent.mov", 5}, {"second.mov", 0}} {
+		j := transcodertest.NewJob(nil)
+		j.Params["input"] = job.input
+		j.Priority = job.priority
+		if err := pool.Submit(j); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pool.Resize(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var inputs []string
+	for _, cmd := range executor.Commands() {
+		inputs = append(inputs, cmd.Args[5])
+	}
+	want := []string{"urgent.mov", "first.mov", "second.mov", "backfill.mov"}
+	if !equal(inputs, want) {
+		t.Errorf("ran %v, want %v", inputs, want)
+	}
+}
+
+// panicExecutor - panics starting the first command, then runs the rest with Executor
+type panicExecutor struct {
+	transcoder.Executor
+	mu       sync.Mutex
+	panicked bool
+}
+
+func (e *panicExecutor) Start(ctx context.Context, cmd *transcoder.Command, stdin io.Reader) (transcoder.Process, error) {
+	e.mu.Lock()
+	panicked := e.panicked
+	e.panicked = true
+	e.mu.Unlock()
+	if !panicked {
+		panic("executor exploded")
+	}
+	return e.Executor.Start(ctx, cmd, stdin)
+}
+
+func TestPoolPanic(t *testing.T) {
+	pool := transcoder.NewPool(1, nil)
+	pool.Executor = &panicExecutor{Executor: transcodertest.NewExecutor(transcodertest.Progress)}
+
+	bad := transcodertest.NewJob(nil)
+	good := transcodertest.NewJob(nil)
+	for _, job := range []*transcoder.Job{bad, good} {
+		if err := pool.Submit(job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bad.Wait()
+	good.Wait()
+
+	if bad.Status != transcoder.JobStatusFailed || bad.ErrorCode != transcoder.ErrorCodePanic {
+		t.Errorf("panicked job = %q %q, want %q %q", bad.Status, bad.ErrorCode, transcoder.JobStatusFailed, transcoder.ErrorCodePanic)
+	}
+	var panicErr *transcoder.PanicError
+	if !errors.As(bad.Err(), &panicErr) || panicErr.Stack == "" {
+		t.Errorf("Err() = %v, want a PanicError with a stack", bad.Err())
+	}
+	if !strings.Contains(bad.CommandOutput, "executor exploded") {
+		t.Errorf("CommandOutput = %q, want the panic", bad.CommandOutput)
+	}
+	if good.Status != transcoder.JobStatusDone {
+		t.Errorf("next job Status = %q, want %q", good.Status, transcoder.JobStatusDone)
+	}
+
+	stats := pool.Stats()
+	if stats.Size != 1 || len(stats.Workers) != 1 || stats.Restarts != 1 {
+		t.Errorf("Stats() size %d workers %d restarts %d, want 1 1 1", stats.Size, len(stats.Workers), stats.Restarts)
+	}
+	if err := pool.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() = %v", err)
+	}
+}
+
+func TestPoolMiddleware(t *testing.T) {
+	var mu sync.Mutex
+	var calls []string
+	record := func(name string) transcoder.Middleware {
+		return func(next transcoder.Handler) transcoder.Handler {
+			return func(ctx context.Context, job *transcoder.Job) error {
+				mu.Lock()
+				calls = append(calls, name+" before")
+				mu.Unlock()
+				err := next(ctx, job)
+				mu.Lock()
+				calls = append(calls, name+" after")
+				mu.Unlock()
+				return err
+			}
+		}
+	}
+	errLocked := errors.New("input is locked")
+	validate := func(next transcoder.Handler) transcoder.Handler {
+		return func(ctx context.Context, job *transcoder.Job) error {
+			if job.Params["input"] == "locked.mov" {
+				return errLocked
+			}
+			return next(ctx, job)
+		}
+	}
+
+	pool := transcoder.NewPool(1, nil)
+	pool.Executor = transcodertest.NewExecutor(transcodertest.Progress)
+	pool.Use(record("outer"), record("inner"))
+	pool.Use(validate)
+	defer pool.Shutdown(context.Background())
+
+	job := transcodertest.NewJob(nil)
+	if err := pool.Submit(job); err != nil {
+		t.Fatal(err)
+	}
+	job.Wait()
+	if job.Status != transcoder.JobStatusDone {
+		t.Fatalf("Status = %q, want %q", job.Status, transcoder.JobStatusDone)
+	}
+	want := []string{"outer before", "inner before", "inner after", "outer after"}
+	mu.Lock()
+	got := calls
+	mu.Unlock()
+	if !equal(got, want) {
+		t.Errorf("calls = %v, want %v", got, want)
+	}
+
+	locked := transcodertest.NewJob(nil)
+	locked.Params["input"] = "locked.mov"
+	if err := pool.Submit(locked); err != nil {
+		t.Fatal(err)
+	}
+	locked.Wait()
+	if locked.Status != transcoder.JobStatusFailed || !errors.Is(locked.Err(), errLocked) {
+		t.Errorf("locked job = %q %v, want %q %v", locked.Status, locked.Err(), transcoder.JobStatusFailed, errLocked)
+	}
+	if len(locked.Attempts) != 0 {
+		t.Errorf("Attempts = %+v, want none", locked.Attempts)
+	}
+}
+
+// waitForStarted - read started events of a Pool until job starts
+// Job.Status is written by the worker without a lock, so tests wait on events instead
+func waitForStarted(t *testing.T, started <-chan transcoder.JobEvent, job *transcoder.Job) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case event := <-started:
+			if event.JobID == job.ID {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("job %v never started", job.ID)
+		}
+	}
+}
@@ -1,6 +1,10 @@
 package transcoder
 
-import "github.com/google/uuid"
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
 
 type PresetGroup struct {
 	ID      uuid.UUID `json:"id"`
@@ -15,7 +19,55 @@ type Preset struct {
 	PresetGroupID *uuid.UUID `json:"presetGroupId,omitempty"`
 
 	// Arguments to pass to executable
-	// Any arguments that should be replaced by job Params should be delimited by "{{" and "}}"
+	// Any text that should be replaced by job Params should be delimited by "{{" and "}}"
 	// Example: {{input}} will get replaced if "input" is present in job.Params map
+	// Placeholders can appear anywhere in an argument and take defaults and filters, see Render
+	// Example: "scale={{width|1280}}:-2", "{{output|dir}}/thumb.jpg"
+	// Jobs fail if a placeholder has no param and no default
 	Args []string `json:"args"`
+
+	// Env - "KEY=value" entries added to the inherited environment, templated like Args
+	// Example: "FFREPORT=file={{output|dir}}/ffreport.log:level=32"
+	Env []string `json:"env,omitempty"`
+	// Dir - working directory, templated like Args. Empty uses the current directory
+	Dir string `json:"dir,omitempty"`
+	// Stdin - optional file or job param piped into the process
+	// StopQuit can't write to stdin when it is used, stopping falls back to SIGINT
+	Stdin *StdinSource `json:"stdin,omitempty"`
+
+	// Pre - hooks run in order before the command, such as creating the output directory
+	// Post - hooks run in order after the command succeeded, such as moving the result into place
+	// Hooks run again on every attempt, a failing hook fails the job with a *HookError
+	Pre  []Hook `json:"pre,omitempty"`
+	Post []Hook `json:"post,omitempty"`
+
+	// AtomicOutput - name of the param holding the output path, usually "output", to write atomically
+	// The command and hooks get a path inside a hidden directory next to the output instead,
+	// files written there are renamed into place only after the job succeeds and removed otherwise.
+	// Only works when the Executor shares this machine's filesystem
+	AtomicOutput string `json:"atomicOutput,omitempty"`
+
+	// ProbeInput - name of the param holding the input path, usually "input", to inspect with ffprobe
+	// before running. The result is stored in Job.Probe and its duration used for progress.
+	// A failed probe is logged and the job runs anyway, unless Verify.DurationTolerance needs the input duration
+	ProbeInput string `json:"probeInput,omitempty"`
+
+	// Verify - checks on the output before the job is done, nil trusts the exit code.
+	// Only works when the Executor shares this machine's filesystem
+	Verify *Verification `json:"verify,omitempty"`
+
+	// Params - job params this preset accepts, checked by Validate and NewJob
+	Params []ParamSpec `json:"params,omitempty"`
+
+	// Timeout - max run time for jobs using this preset. Zero means no limit
+	Timeout time.Duration `json:"timeout,omitempty"`
+
+	// Stop - how to stop running jobs on Kill or timeout. nil uses DefaultStopPolicy
+	Stop *StopPolicy `json:"stop,omitempty"`
+
+	// Retry - run failed jobs again. nil runs each job once
+	Retry *RetryPolicy `json:"retry,omitempty"`
+
+	// Cost - resources a job holds in a Pool with a capacity. nil uses DefaultCost
+	Cost *Cost `json:"cost,omitempty"`
 }
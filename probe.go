@@ -0,0 +1,67 @@
+package transcoder
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/palmdalian/transcoder/ffprobe"
+)
+
+// probeInput - the input path to inspect, empty unless the preset opts in
+func (job *Job) probeInput() string {
+	if job.Preset == nil || job.Preset.ProbeInput == "" {
+		return ""
+	}
+	return job.Params[job.Preset.ProbeInput]
+}
+
+// probeRequired - whether a failed probe fails the job, only when verification needs the input duration
+func (job *Job) probeRequired() bool {
+	return job.Preset != nil && job.Preset.Verify != nil && job.Preset.Verify.DurationTolerance > 0
+}
+
+// runProbe - inspect the input with ffprobe through the job Executor and store the result in job.Probe
+// Retries reuse the first result, its duration is what progress is calculated against
+func (job *Job) runProbe(ctx context.Context) error {
+	if job.Probe == nil {
+		result, err := job.probe(ctx, job.probeInput(), "[probe] ")
+		if err != nil {
+			return err
+		}
+		job.mu.Lock()
+		job.Probe = result
+		job.mu.Unlock()
+	}
+	if duration := job.Probe.Duration(); duration > 0 {
+		job.setTotalDuration(duration)
+	}
+	return nil
+}
+
+// probe - run ffprobe on path through the job Executor, its stderr goes to the job output behind prefix
+func (job *Job) probe(ctx context.Context, path, prefix string) (*ffprobe.Result, error) {
+	command := &Command{Path: ffprobe.Path, Args: ffprobe.Args(path)}
+	var stdout bytes.Buffer
+	err := job.runSideCommand(ctx, command,
+		func(r io.Reader) { stdout.ReadFrom(r) },
+		func(r io.Reader) { readPrefixed(r, prefix, job.appendErrOutput) },
+	)
+	if err != nil {
+		return nil, fmt.Errorf("probing %s %w", path, err)
+	}
+	result, err := ffprobe.Parse(&stdout)
+	if err != nil {
+		return nil, fmt.Errorf("probing %s %w", path, err)
+	}
+	return result, nil
+}
+
+// probedDuration - input duration from ffprobe, 0 if unknown
+func (job *Job) probedDuration() float64 {
+	if job.Probe == nil {
+		return 0
+	}
+	return job.Probe.Duration()
+}
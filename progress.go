@@ -0,0 +1,101 @@
+package transcoder
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Progress - snapshot of the latest ffmpeg -progress block
+// Times are in seconds. Fields ffmpeg reports as N/A are left at zero
+type Progress struct {
+	Frame      int64     `json:"frame"`
+	FPS        float64   `json:"fps"`
+	Bitrate    float64   `json:"bitrate"` // kbits/s
+	TotalSize  int64     `json:"totalSize"`
+	OutTime    float64   `json:"outTime"`
+	DupFrames  int64     `json:"dupFrames"`
+	DropFrames int64     `json:"dropFrames"`
+	Speed      float64   `json:"speed"`
+	Duration   float64   `json:"duration"`
+	Percent    float64   `json:"percent"`
+	ETA        float64   `json:"eta"`
+	Done       bool      `json:"done"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+// progressParser - collect key=value lines until ffmpeg ends the block with progress=continue|end
+type progressParser struct {
+	current Progress
+}
+
+// parseLine - returns true when the line completes a block
+func (p *progressParser) parseLine(line string) bool {
+	split := strings.SplitN(strings.TrimSpace(line), "=", 2)
+	if len(split) != 2 {
+		return false
+	}
+	key, val := split[0], strings.TrimSpace(split[1])
+
+	switch key {
+	case "frame":
+		p.current.Frame = parseInt(val)
+	case "fps":
+		p.current.FPS = parseFloat(val)
+	case "bitrate":
+		p.current.Bitrate = parseFloat(strings.TrimSuffix(val, "kbits/s"))
+	case "total_size":
+		p.current.TotalSize = parseInt(val)
+	case "out_time_us", "out_time_ms":
+		// ffmpeg reports microseconds for both keys
+		if us := parseInt(val); us > 0 {
+			p.current.OutTime = float64(us) / 1000000
+		}
+	case "dup_frames":
+		p.current.DupFrames = parseInt(val)
+	case "drop_frames":
+		p.current.DropFrames = parseInt(val)
+	case "speed":
+		p.current.Speed = parseFloat(strings.TrimSuffix(val, "x"))
+	case "progress":
+		p.current.Done = val == "end"
+		p.current.UpdatedAt = time.Now()
+		return true
+	}
+	return false
+}
+
+// calculate - fill Duration, Percent and ETA from the total duration in seconds
+func (progress *Progress) calculate(duration float64) {
+	progress.Duration = duration
+	progress.Percent = 0
+	progress.ETA = 0
+	if progress.Done {
+		progress.Percent = 100
+		return
+	}
+	if duration <= 0 {
+		return
+	}
+	progress.Percent = math.Min(math.Round(progress.OutTime/duration*1000)/10, 100)
+	if progress.Speed > 0 {
+		progress.ETA = math.Max((duration-progress.OutTime)/progress.Speed, 0)
+	}
+}
+
+func parseInt(val string) int64 {
+	i, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return i
+}
+
+func parseFloat(val string) float64 {
+	f, err := strconv.ParseFloat(val, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0
+	}
+	return f
+}
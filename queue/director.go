@@ -3,8 +3,10 @@ package queue
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"sync"
 	"time"
 
 	"github.com/palmdalian/transcoder"
@@ -14,62 +16,100 @@ import (
 	"github.com/google/uuid"
 )
 
+// Priority levels, each sent to its own rmq.Queue
+// Jobs with a Priority above zero are sent to the high queue and below zero to the low queue
+const (
+	PriorityHigh   = "high"
+	PriorityNormal = "normal"
+	PriorityLow    = "low"
+)
+
+var priorityLevels = []string{PriorityHigh, PriorityNormal, PriorityLow}
+
 // Director connects to rmq.Queue to submit, consume, reject, and ack deliveries
-// Any consumed deliveries are sent to the job queue
+// Any consumed deliveries are submitted to the worker pool
 type Director struct {
-	jobQueue    chan *transcoder.Job
-	taskQueue   rmq.Queue
+	pool        *transcoder.Pool
+	connection  rmq.Connection
+	taskQueues  map[string]rmq.Queue // by priority level
+	levelKeys   map[string]levelKeys // by priority level
 	redisClient redis.UniversalClient
+	pollers     sync.WaitGroup
+	stopping    chan struct{}
+
+	mu          sync.Mutex
+	pollerStops []chan struct{} // one per running poller, closed to stop it after its current job
 }
 
-// NewDirector opens rmq.Queue and starts worker pool to run jobs
+// NewDirector opens a rmq.Queue per priority level and starts worker pool to run jobs
 // Director both Consumes and Submits rmq deliveries
-func NewDirector(queueName string, workerNum int, redisClient redis.UniversalClient, jobUpdatesChan chan *transcoder.JobStatus) (*Director, error) {
+// Each worker has a poller taking one delivery at a time from the highest priority level that has one
+func NewDirector(queueName string, workerNum int, redisClient redis.UniversalClient, events *transcoder.EventBus) (*Director, error) {
 	rClient, ok := redisClient.(*redis.Client)
 	if !ok {
 		return nil, fmt.Errorf("could not assert redis client")
 	}
 
+	// rmq reports errors for as long as the connection is open, errChan is never closed
 	errChan := make(chan error)
-	defer close(errChan)
 	go logErrors(errChan)
 	connection, err := rmq.OpenConnectionWithRedisClient(uuid.NewString(), rClient, errChan)
 	if err != nil {
 		return nil, fmt.Errorf("could not open rmq connection %w", err)
 	}
 
-	taskQueue, err := connection.OpenQueue(queueName)
-	if err != nil {
-		return nil, fmt.Errorf("could not open rmq queue %w", err)
-	}
-
-	go startCleaner(connection)
-	go startPurger(taskQueue)
+	// rmq connections print their name, which the unacked lists are keyed by
+	connectionName := fmt.Sprint(connection)
 
-	err = taskQueue.StartConsuming(int64(workerNum), time.Second)
-	if err != nil {
-		log.Fatalf("Could not start consuming %v", err)
-	}
-
-	jobQueue := make(chan *transcoder.Job, 100)
 	director := &Director{
-		jobQueue:    jobQueue,
-		taskQueue:   taskQueue,
+		pool:        transcoder.NewPool(workerNum, events),
+		connection:  connection,
+		taskQueues:  make(map[string]rmq.Queue),
+		levelKeys:   make(map[string]levelKeys),
 		redisClient: redisClient,
+		stopping:    make(chan struct{}),
 	}
+	go startCleaner(connection)
 
-	for i := 0; i < workerNum; i++ {
-		if _, err := taskQueue.AddConsumer(fmt.Sprintf("consumer-%d", i), director); err != nil {
-			return nil, err
+	for _, level := range priorityLevels {
+		name := levelQueueName(queueName, level)
+		taskQueue, err := connection.OpenQueue(name)
+		if err != nil {
+			return nil, fmt.Errorf("could not open rmq queue %w", err)
+		}
+		director.taskQueues[level] = taskQueue
+		director.levelKeys[level] = newLevelKeys(connectionName, name)
+		go startPurger(taskQueue)
+
+		if err := registerLevel(redisClient, connectionName, name); err != nil {
+			return nil, fmt.Errorf("could not register rmq queue %w", err)
 		}
-		worker := transcoder.NewWorker(jobQueue, jobUpdatesChan)
-		worker.Name = fmt.Sprintf("Worker%d", i)
 	}
 
+	director.resizePollers(workerNum)
 	return director, nil
 }
 
-// Consume - implement rmq interface to receive jobs from redis queue
+// levelQueueName - the normal level keeps queueName so deliveries sent before priorities are still consumed
+func levelQueueName(queueName, level string) string {
+	if level == PriorityNormal {
+		return queueName
+	}
+	return fmt.Sprintf("%s-%s", queueName, level)
+}
+
+// priorityLevel - level of the queue a job with priority is sent to
+func priorityLevel(priority int) string {
+	switch {
+	case priority > 0:
+		return PriorityHigh
+	case priority < 0:
+		return PriorityLow
+	}
+	return PriorityNormal
+}
+
+// Consume - run the job of a delivery and ack it once done, also implements rmq.Consumer
 func (director *Director) Consume(delivery rmq.Delivery) {
 	job := &transcoder.Job{}
 	err := json.Unmarshal([]byte(delivery.Payload()), job)
@@ -90,9 +130,17 @@ func (director *Director) Consume(delivery rmq.Delivery) {
 	defer cancelFunc()
 	go director.commandReader(ctx, job)
 
-	director.jobQueue <- job
+	if err = director.pool.Submit(job); err != nil {
+		// Left unacked, the cleaner returns it to the queue once this connection is gone
+		log.Printf("Err submitting job %v: %v", job.ID, err)
+		return
+	}
 	job.Wait()
-	if job.Err() != nil {
+	if err = job.Err(); err != nil {
+		if director.interrupted(job, err) {
+			log.Printf("Job %v interrupted by shutdown, leaving it for another director", job.ID)
+			return
+		}
 		reject(delivery)
 		return
 	}
@@ -103,14 +151,115 @@ func (director *Director) Consume(delivery rmq.Delivery) {
 	}
 }
 
-// SendToQueue send new job to rmq.Queue to be picked up by any listening directors
+// Shutdown - stop polling for deliveries and drain the worker pool
+// Jobs still running at the ctx deadline are stopped and their deliveries left unacked,
+// so the cleaner of another director returns them to the queue
+func (director *Director) Shutdown(ctx context.Context) error {
+	director.stopPolling()
+	err := director.pool.Shutdown(ctx)
+	director.pollers.Wait()
+	return err
+}
+
+// ShutdownWithin - Shutdown that is over by the ctx deadline, see Pool.ShutdownWithin
+func (director *Director) ShutdownWithin(ctx context.Context) error {
+	director.stopPolling()
+	err := director.pool.ShutdownWithin(ctx)
+	polled := make(chan struct{})
+	go func() {
+		director.pollers.Wait()
+		close(polled)
+	}()
+	select {
+	case <-polled:
+	case <-ctx.Done():
+	}
+	return err
+}
+
+// stopPolling - pollers take no more deliveries and Resize starts none
+func (director *Director) stopPolling() {
+	director.mu.Lock()
+	defer director.mu.Unlock()
+	select {
+	case <-director.stopping:
+	default:
+		close(director.stopping)
+	}
+}
+
+// Resize - change how many consumed jobs run at once, starting or stopping a poller per worker
+// Stopped pollers take no more deliveries once their current job is done
+func (director *Director) Resize(size int) error {
+	director.mu.Lock()
+	defer director.mu.Unlock()
+	if err := director.pool.Resize(size); err != nil {
+		return err
+	}
+	director.resizePollers(size)
+	return nil
+}
+
+// resizePollers - start or stop pollers until size are running, none are started once Shutdown was called
+// caller holds mu unless the director isn't shared yet
+func (director *Director) resizePollers(size int) {
+	select {
+	case <-director.stopping:
+		return
+	default:
+	}
+	for len(director.pollerStops) < size {
+		stop := make(chan struct{})
+		director.pollerStops = append(director.pollerStops, stop)
+		director.pollers.Add(1)
+		go director.poll(stop)
+	}
+	for len(director.pollerStops) > size {
+		last := len(director.pollerStops) - 1
+		close(director.pollerStops[last])
+		director.pollerStops = director.pollerStops[:last]
+	}
+}
+
+// SetCapacity - budget shared by the jobs this director runs, see Pool.SetCapacity
+func (director *Director) SetCapacity(capacity transcoder.Cost) {
+	director.pool.SetCapacity(capacity)
+}
+
+// SetExecutor - start the commands of jobs this director runs with executor, see Pool.SetExecutor
+// Consumed jobs are decoded without one, otherwise they use transcoder.DefaultExecutor
+func (director *Director) SetExecutor(executor transcoder.Executor) {
+	director.pool.SetExecutor(executor)
+}
+
+// Use - wrap jobs this director runs with middleware, see Pool.Use
+func (director *Director) Use(middleware ...transcoder.Middleware) {
+	director.pool.Use(middleware...)
+}
+
+// Stats - worker pool stats of this director
+func (director *Director) Stats() transcoder.PoolStats {
+	return director.pool.Stats()
+}
+
+// interrupted - job failed because the director is shutting down rather than on its own
+func (director *Director) interrupted(job *transcoder.Job, err error) bool {
+	select {
+	case <-director.stopping:
+	default:
+		return false
+	}
+	return errors.Is(err, transcoder.ErrPoolClosed) || job.Status == transcoder.JobStatusCanceled
+}
+
+// SendToQueue send new job to the rmq.Queue of its priority level to be picked up by any listening directors
 func (director *Director) SendToQueue(job *transcoder.Job) error {
 	taskBytes, err := json.Marshal(job)
 	if err != nil {
 		return fmt.Errorf("could not marshal job %w", err)
 	}
 
-	err = director.taskQueue.PublishBytes(taskBytes)
+	err = director.taskQueues[priorityLevel(job.Priority)].PublishBytes(taskBytes)
 	if err != nil {
 		return fmt.Errorf("could not open publish queue %w", err)
 	}
@@ -148,12 +297,27 @@ func (director *Director) sendCommand(ctx context.Context, jobID uuid.UUID, comm
 
 // PurgeReady remove any unAcked jobs before work is started
 func (director *Director) PurgeReady() (ready int64, err error) {
-	return director.taskQueue.PurgeReady()
+	for _, taskQueue := range director.taskQueues {
+		count, err := taskQueue.PurgeReady()
+		ready += count
+		if err != nil {
+			return ready, err
+		}
+	}
+	return ready, nil
 }
 
 // DestroyQueue remove any running or queued jobs
 func (director *Director) DestroyQueue() (ready, rejected int64, err error) {
-	return director.taskQueue.Destroy()
+	for _, taskQueue := range director.taskQueues {
+		readyCount, rejectedCount, err := taskQueue.Destroy()
+		ready += readyCount
+		rejected += rejectedCount
+		if err != nil {
+			return ready, rejected, err
+		}
+	}
+	return ready, rejected, nil
 }
 
 // commandReader Subscribe to a job-specific PubSub to relay commands from any pod
@@ -176,11 +340,14 @@ func (director *Director) commandReader(ctx context.Context, job *transcoder.Job
 				log.Println(err)
 			}
 		case jobCmdKill:
-			stat := &transcoder.JobStatus{Status: "killed", Job: job}
 			log.Printf("Killing %v", job.ID)
-			if err := job.Kill(); err != nil {
-				stat.Status = job.Status
+			err := job.Kill()
+			// The worker keeps writing the job, NewJobStatus marshals a copy
+			stat := transcoder.NewJobStatus(job, "")
+			if err != nil {
 				stat.Message = fmt.Sprintf("Could not kill %v", err)
+			} else {
+				stat.Status = "killed"
 			}
 			b, err := json.Marshal(stat)
 			if err != nil {
@@ -208,6 +375,7 @@ func infoChannel(jobID uuid.UUID) string {
 }
 
 // reject - rejected deliveries will not be retried
+// Workers have already retried the job according to its RetryPolicy
 func reject(delivery rmq.Delivery) {
 	if err := delivery.Reject(); err != nil {
 		log.Printf("Err rejecting delivery after submit %v", err)
@@ -0,0 +1,220 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/palmdalian/transcoder"
+	"github.com/palmdalian/transcoder/transcodertest"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+func newTestDirector(t *testing.T, executor transcoder.Executor) (*Director, <-chan transcoder.JobEvent) {
+	t.Helper()
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Close)
+
+	redisClient := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	events := transcoder.NewEventBus()
+	updates, unsubscribe := events.Subscribe(transcoder.SubscribeOptions{Types: transcoder.StatusEventTypes})
+	t.Cleanup(unsubscribe)
+	director, err := NewDirector("jobs", 1, redisClient, events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Jobs are unmarshaled from the queue without an Executor
+	director.SetExecutor(executor)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		director.Shutdown(ctx)
+	})
+	return director, updates
+}
+
+func TestDirectorConsume(t *testing.T) {
+	director, updates := newTestDirector(t, transcodertest.NewExecutor(transcodertest.Progress))
+
+	job := transcodertest.NewJob(nil)
+	if err := director.SendToQueue(job); err != nil {
+		t.Fatal(err)
+	}
+
+	update := waitForStatus(t, updates, transcoder.JobStatusDone)
+	if update.Job.ID != job.ID {
+		t.Errorf("done job %v, want %v", update.Job.ID, job.ID)
+	}
+}
+
+func TestDirectorJobInfoAndKill(t *testing.T) {
+	director, updates := newTestDirector(t, transcodertest.NewExecutor(transcodertest.Script{Hang: true}))
+
+	job := transcodertest.NewJob(nil)
+	if err := director.SendToQueue(job); err != nil {
+		t.Fatal(err)
+	}
+	waitForStatus(t, updates, transcoder.JobStatusInProgress)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	// The command reader subscribes right after the job is picked up, retry until it is listening
+	var info string
+	for info == "" {
+		attemptCtx, attemptCancel := context.WithTimeout(ctx, 100*time.Millisecond)
+		info, _ = director.JobInfo(attemptCtx, job.ID)
+		attemptCancel()
+		if ctx.Err() != nil {
+			t.Fatal("no job info received")
+		}
+	}
+
+	if _, err := director.KillJob(ctx, job.ID); err != nil {
+		t.Fatalf("KillJob() = %v", err)
+	}
+	update := waitForStatus(t, updates, transcoder.JobStatusFailed)
+	if update.ErrorCode != transcoder.ErrorCodeKilled {
+		t.Errorf("ErrorCode = %q, want %q", update.ErrorCode, transcoder.ErrorCodeKilled)
+	}
+}
+
+func TestDirectorPriority(t *testing.T) {
+	executor := transcodertest.NewExecutor(transcodertest.Progress)
+	executor.Script = func(cmd *transcoder.Command) transcodertest.Script {
+		script := transcodertest.Progress
+		if strings.Contains(cmd.String(), "slow.mov") {
+			// Long enough for the other queues to be polled
+			script.StepDelay = 500 * time.Millisecond
+		}
+		return script
+	}
+	director, updates := newTestDirector(t, executor)
+
+	running := transcodertest.NewJob(nil)
+	running.Params["input"] = "slow.mov"
+	if err := director.SendToQueue(running); err != nil {
+		t.Fatal(err)
+	}
+	waitForStatus(t, updates, transcoder.JobStatusInProgress)
+
+	// Both stay in redis behind the running job, the high priority one is taken first
+	backfill := transcodertest.NewJob(nil)
+	backfill.Priority = -1
+	urgent := transcodertest.NewJob(nil)
+	urgent.Priority = 1
+	for _, job := range []*transcoder.Job{backfill, urgent} {
+		if err := director.SendToQueue(job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	if unacked := unackedCount(t, director); unacked != 1 {
+		t.Errorf("%d unacked deliveries, want only the running one", unacked)
+	}
+	if queued := director.Stats().Queued; queued != 0 {
+		t.Errorf("%d jobs queued in the pool, want 0", queued)
+	}
+
+	for _, want := range []*transcoder.Job{urgent, backfill} {
+		update := waitForStatus(t, updates, transcoder.JobStatusInProgress)
+		if update.Job.ID != want.ID {
+			t.Errorf("started job with priority %d, want %d", update.Job.Priority, want.Priority)
+		}
+	}
+}
+
+func TestDirectorResize(t *testing.T) {
+	director, updates := newTestDirector(t, transcodertest.NewExecutor(transcodertest.Script{Hang: true}))
+
+	// Grows past the worker count given to NewDirector
+	if err := director.Resize(2); err != nil {
+		t.Fatalf("Resize(2) = %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := director.SendToQueue(transcodertest.NewJob(nil)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitForStatus(t, updates, transcoder.JobStatusInProgress)
+	waitForStatus(t, updates, transcoder.JobStatusInProgress)
+	if busy := director.Stats().Busy; busy != 2 {
+		t.Errorf("%d busy workers, want 2", busy)
+	}
+
+	if err := director.Resize(0); err != nil {
+		t.Fatalf("Resize(0) = %v", err)
+	}
+	if err := director.SendToQueue(transcodertest.NewJob(nil)); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if unacked := unackedCount(t, director); unacked != 2 {
+		t.Errorf("%d unacked deliveries, want only the two running ones", unacked)
+	}
+}
+
+// unackedCount - deliveries held by director across every priority level
+func unackedCount(t *testing.T, director *Director) int64 {
+	t.Helper()
+	var count int64
+	for _, keys := range director.levelKeys {
+		n, err := director.redisClient.LLen(context.Background(), keys.unacked).Result()
+		if err != nil {
+			t.Fatal(err)
+		}
+		count += n
+	}
+	return count
+}
+
+// waitForStatus - read updates until one has status
+func waitForStatus(t *testing.T, updates <-chan transcoder.JobEvent, status string) transcoder.JobEvent {
+	t.Helper()
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case update := <-updates:
+			if update.Status == status {
+				return update
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for %q", status)
+		}
+	}
+}
+
+func TestDirectorShutdown(t *testing.T) {
+	director, updates := newTestDirector(t, transcodertest.NewExecutor(transcodertest.Script{Hang: true}))
+
+	job := transcodertest.NewJob(nil)
+	if err := director.SendToQueue(job); err != nil {
+		t.Fatal(err)
+	}
+	waitForStatus(t, updates, transcoder.JobStatusInProgress)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := director.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Shutdown() = %v, want deadline exceeded", err)
+	}
+	waitForStatus(t, updates, transcoder.JobStatusCanceled)
+
+	// Interrupted deliveries stay unacked for another director instead of being rejected
+	rejected, err := director.taskQueues[PriorityNormal].PurgeRejected()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rejected != 0 {
+		t.Errorf("rejected %d deliveries, want 0", rejected)
+	}
+	if unacked := unackedCount(t, director); unacked != 1 {
+		t.Errorf("%d unacked deliveries, want the interrupted one", unacked)
+	}
+}
@@ -0,0 +1,129 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/adjust/rmq/v4"
+	"github.com/go-redis/redis/v8"
+)
+
+// PollInterval - how long an idle poller waits before checking the priority levels again
+var PollInterval = time.Second
+
+// Key layout of rmq v4, deliveries are moved between these lists directly so one poller per worker
+// can take from the levels in priority order. The rmq cleaner still returns the unacked deliveries of a dead director
+// rmq is pinned in go.mod, TestRMQKeyLayout fails if an upgrade changes these
+const (
+	connectionQueuesTemplate = "rmq::connection::%s::queues"
+	unackedTemplate          = "rmq::connection::%s::queue::[%s]::unacked"
+	readyTemplate            = "rmq::queue::[%s]::ready"
+	rejectedTemplate         = "rmq::queue::[%s]::rejected"
+)
+
+// levelKeys - redis lists of one priority level rmq.Queue
+type levelKeys struct {
+	ready    string
+	unacked  string // deliveries taken by this director's connection
+	rejected string
+}
+
+func newLevelKeys(connectionName, queueName string) levelKeys {
+	return levelKeys{
+		ready:    fmt.Sprintf(readyTemplate, queueName),
+		unacked:  fmt.Sprintf(unackedTemplate, connectionName, queueName),
+		rejected: fmt.Sprintf(rejectedTemplate, queueName),
+	}
+}
+
+// registerLevel - add queueName to the queues consumed by the connection, as rmq.Queue.StartConsuming does
+// Lets the cleaner and rmq's stats find the unacked deliveries of this connection
+func registerLevel(redisClient redis.UniversalClient, connectionName, queueName string) error {
+	return redisClient.SAdd(context.Background(), fmt.Sprintf(connectionQueuesTemplate, connectionName), queueName).Err()
+}
+
+// delivery - rmq.Delivery taken from a priority level by a poller
+type delivery struct {
+	payload     string
+	keys        levelKeys
+	redisClient redis.UniversalClient
+}
+
+func (d *delivery) Payload() string {
+	return d.payload
+}
+
+// Ack - remove from the unacked list
+func (d *delivery) Ack() error {
+	count, err := d.redisClient.LRem(context.Background(), d.keys.unacked, 1, d.payload).Result()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return rmq.ErrorNotFound
+	}
+	return nil
+}
+
+// Reject - move to the rejected list
+func (d *delivery) Reject() error {
+	if err := d.redisClient.LPush(context.Background(), d.keys.rejected, d.payload).Err(); err != nil {
+		return err
+	}
+	return d.Ack()
+}
+
+// Push - levels have no push queue, rejects like rmq does
+func (d *delivery) Push() error {
+	return d.Reject()
+}
+
+// poll - consume deliveries for one worker until stop is closed or Shutdown
+// Each poller waits for its job, so the director holds at most one unacked delivery per worker
+func (director *Director) poll(stop <-chan struct{}) {
+	defer director.pollers.Done()
+	for {
+		select {
+		case <-director.stopping:
+			return
+		case <-stop:
+			return
+		default:
+		}
+
+		next, err := director.take()
+		if err != nil {
+			log.Printf("Err polling queues %v", err)
+		}
+		if next == nil {
+			select {
+			case <-director.stopping:
+				return
+			case <-stop:
+				return
+			case <-time.After(PollInterval):
+			}
+			continue
+		}
+		director.Consume(next)
+	}
+}
+
+// take - move the oldest ready delivery of the highest priority level to the unacked list, nil if every level is empty
+func (director *Director) take() (rmq.Delivery, error) {
+	for _, level := range priorityLevels {
+		keys := director.levelKeys[level]
+		payload, err := director.redisClient.RPopLPush(context.Background(), keys.ready, keys.unacked).Result()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &delivery{payload: payload, keys: keys, redisClient: director.redisClient}, nil
+	}
+	return nil, nil
+}
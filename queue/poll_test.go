@@ -0,0 +1,79 @@
+package queue
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/adjust/rmq/v4"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+// TestRMQKeyLayout - pollers move deliveries between rmq's lists directly, check rmq still finds them there
+func TestRMQKeyLayout(t *testing.T) {
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	redisClient := redis.NewClient(&redis.Options{Addr: server.Addr()})
+
+	connection, err := rmq.OpenConnectionWithRedisClient("layout", redisClient, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connection.StopAllConsuming()
+	taskQueue, err := connection.OpenQueue("jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := taskQueue.Publish("first", "second"); err != nil {
+		t.Fatal(err)
+	}
+
+	connectionName := fmt.Sprint(connection)
+	if err := registerLevel(redisClient, connectionName, "jobs"); err != nil {
+		t.Fatal(err)
+	}
+	director := &Director{
+		levelKeys:   map[string]levelKeys{PriorityNormal: newLevelKeys(connectionName, "jobs")},
+		redisClient: redisClient,
+	}
+
+	// Taken oldest first into the unacked list of this connection
+	taken, err := director.take()
+	if err != nil || taken == nil {
+		t.Fatalf("take() = %v, %v, want a delivery", taken, err)
+	}
+	if taken.Payload() != "first" {
+		t.Errorf("took %q, want the oldest delivery", taken.Payload())
+	}
+	checkStats(t, connection, 1, 1, 0)
+
+	if err := taken.Reject(); err != nil {
+		t.Fatal(err)
+	}
+	checkStats(t, connection, 1, 0, 1)
+
+	if taken, err = director.take(); err != nil || taken == nil {
+		t.Fatalf("take() = %v, %v, want a delivery", taken, err)
+	}
+	if err := taken.Ack(); err != nil {
+		t.Fatal(err)
+	}
+	checkStats(t, connection, 0, 0, 1)
+}
+
+// checkStats - compare rmq's own counts for the jobs queue, unacked is found through the queues of each connection like the cleaner does
+func checkStats(t *testing.T, connection rmq.Connection, ready, unacked, rejected int64) {
+	t.Helper()
+	stats, err := connection.CollectStats([]string{"jobs"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stat := stats.QueueStats["jobs"]
+	if stat.ReadyCount != ready || stat.UnackedCount() != unacked || stat.RejectedCount != rejected {
+		t.Errorf("ready %d, unacked %d, rejected %d, want %d, %d and %d",
+			stat.ReadyCount, stat.UnackedCount(), stat.RejectedCount, ready, unacked, rejected)
+	}
+}
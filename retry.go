@@ -0,0 +1,150 @@
+package transcoder
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// RetryPolicy - how many times a Worker runs a failed job and how long it waits in between
+type RetryPolicy struct {
+	MaxAttempts   int           `json:"maxAttempts"`          // total runs including the first, <= 1 disables retries
+	Backoff       time.Duration `json:"backoff"`              // wait before the first retry
+	MaxBackoff    time.Duration `json:"maxBackoff,omitempty"` // cap for the growing wait, zero for no cap
+	Multiplier    float64       `json:"multiplier,omitempty"` // growth per retry, zero uses 2
+	TransientOnly bool          `json:"transientOnly"`        // only retry ErrorCodes that are Transient
+}
+
+// shouldRetry - true if the job can run again after failing attempt number attempt
+func (policy *RetryPolicy) shouldRetry(code ErrorCode, attempt int) bool {
+	if policy == nil || attempt >= policy.MaxAttempts {
+		return false
+	}
+	// Someone asked for the job to stop, don't start it again
+	if code == ErrorCodeKilled || code == ErrorCodeCanceled {
+		return false
+	}
+	if policy.TransientOnly && !code.Transient() {
+		return false
+	}
+	return true
+}
+
+// backoff - wait after failing attempt number attempt
+func (policy *RetryPolicy) backoff(attempt int) time.Duration {
+	multiplier := policy.Multiplier
+	if multiplier <= 0 {
+		multiplier = 2
+	}
+	wait := time.Duration(float64(policy.Backoff) * math.Pow(multiplier, float64(attempt-1)))
+	if policy.MaxBackoff > 0 && (wait > policy.MaxBackoff || wait < 0) {
+		wait = policy.MaxBackoff
+	}
+	return wait
+}
+
+// retryPolicy - job retry policy if set, otherwise the preset retry policy
+func (job *Job) retryPolicy() *RetryPolicy {
+	if job.Retry != nil {
+		return job.Retry
+	}
+	if job.Preset != nil {
+		return job.Preset.Retry
+	}
+	return nil
+}
+
+// nextAttempt - count a new run, returns its number since the job was last done
+func (job *Job) nextAttempt() int {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	job.attempts++
+	return job.attempts
+}
+
+// attempt - number of the latest run since the job was last done
+func (job *Job) attempt() int {
+	job.mu.RLock()
+	defer job.mu.RUnlock()
+	return job.attempts
+}
+
+// setCancelRetry - how Kill stops the job while it waits out its backoff
+func (job *Job) setCancelRetry(cancel func() bool) {
+	job.mu.Lock()
+	job.cancelRetry = cancel
+	job.mu.Unlock()
+}
+
+// takeCancelRetry - claim the backoff, nil once Kill or the next attempt has claimed it
+func (job *Job) takeCancelRetry() func() bool {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	cancel := job.cancelRetry
+	job.cancelRetry = nil
+	return cancel
+}
+
+// killRetry - fail a job killed while waiting out its backoff
+func killRetry(job *Job) error {
+	err := fmt.Errorf("%w before retry", ErrKilled)
+	job.mu.Lock()
+	job.err = err
+	job.ErrorCode = ErrorCodeKilled
+	job.mu.Unlock()
+	return err
+}
+
+// Attempt - a single run of a job
+type Attempt struct {
+	Number    int       `json:"number"`
+	StartedAt time.Time `json:"startedAt"`
+	EndedAt   time.Time `json:"endedAt"`
+	Worker    string    `json:"worker"`
+	Error     string    `json:"error,omitempty"`
+	ErrorCode ErrorCode `json:"errorCode,omitempty"`
+}
+
+// Attempts - Custom []Attempt for postgres jsonb compatibility
+type Attempts []Attempt
+
+// addAttempt - record the outcome of a run
+func (job *Job) addAttempt(worker string, startedAt time.Time, err error) {
+	attempt := Attempt{
+		Number:    len(job.Attempts) + 1,
+		StartedAt: startedAt,
+		EndedAt:   time.Now(),
+		Worker:    worker,
+	}
+	if err != nil {
+		attempt.Error = err.Error()
+		attempt.ErrorCode = job.ErrorCode
+	}
+	job.mu.Lock()
+	job.Attempts = append(job.Attempts, attempt)
+	job.mu.Unlock()
+}
+
+// Scan - allow retrieving of jsonb -> Attempts
+func (a *Attempts) Scan(value interface{}) error {
+	bytes, ok := value.([]byte)
+	if !ok {
+		return errors.New(fmt.Sprint("Failed to unmarshal JSONB value:", value))
+	}
+
+	result := Attempts{}
+	err := json.Unmarshal(bytes, &result)
+	*a = result
+	return err
+}
+
+// Value - allow saving Attempts as jsonb
+func (a Attempts) Value() (driver.Value, error) {
+	if len(a) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(a)
+}
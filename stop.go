@@ -0,0 +1,94 @@
+package transcoder
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+const (
+	StopQuit      = "quit"      // Write "q" to stdin, ffmpeg finishes writing outputs and exits
+	StopInterrupt = "interrupt" // Send SIGINT to the process group
+	StopTerminate = "terminate" // Send SIGTERM to the process group
+	StopKill      = "kill"      // Send SIGKILL to the process group without a grace period
+)
+
+// ErrKilled - returned from Run when the job was stopped by Kill
+var ErrKilled = errors.New("job killed")
+
+// StopPolicy - how a running process is asked to stop
+// If it hasn't exited after GracePeriod the whole process group is sent SIGKILL
+type StopPolicy struct {
+	Method      string        `json:"method"`
+	GracePeriod time.Duration `json:"gracePeriod"`
+}
+
+// DefaultStopPolicy - used when a Preset does not set its own StopPolicy
+var DefaultStopPolicy = StopPolicy{Method: StopQuit, GracePeriod: 10 * time.Second}
+
+// stopPolicy - preset stop policy if set, otherwise DefaultStopPolicy
+func (job *Job) stopPolicy() StopPolicy {
+	if job.Preset != nil && job.Preset.Stop != nil {
+		return *job.Preset.Stop
+	}
+	return DefaultStopPolicy
+}
+
+// stop - ask the running process to exit and escalate to SIGKILL after the grace period
+// job.mu must be held by the caller
+func (job *Job) stop() error {
+	if job.process == nil {
+		return errors.New("no job to kill")
+	}
+	if job.stopping {
+		return nil
+	}
+	job.stopping = true
+
+	policy := job.stopPolicy()
+	process := job.process
+	var err error
+	switch policy.Method {
+	case StopQuit:
+		if stdin := process.Stdin(); stdin != nil {
+			err = writeQuit(stdin)
+		} else {
+			// stdin is carrying input, ask with SIGINT instead
+			err = process.Signal(os.Interrupt)
+		}
+	case StopInterrupt:
+		err = process.Signal(os.Interrupt)
+	case StopTerminate:
+		err = process.Signal(sigTerm)
+	default:
+		return process.Signal(os.Kill)
+	}
+	// Couldn't ask nicely, don't wait around
+	if err != nil || policy.GracePeriod <= 0 {
+		return process.Signal(os.Kill)
+	}
+
+	exited := job.stopped
+	go func() {
+		timer := time.NewTimer(policy.GracePeriod)
+		defer timer.Stop()
+		select {
+		case <-exited:
+		case <-timer.C:
+			if err := process.Signal(os.Kill); err != nil {
+				job.appendErrOutput(fmt.Sprintf("killing process group %v", err))
+			}
+		}
+	}()
+	return nil
+}
+
+// writeQuit - send ffmpeg's interactive quit command
+func writeQuit(stdin io.WriteCloser) error {
+	if _, err := io.WriteString(stdin, "q\n"); err != nil {
+		return err
+	}
+	return stdin.Close()
+}
@@ -0,0 +1,26 @@
+//go:build !windows
+// +build !windows
+
+package transcoder
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+var sigTerm os.Signal = syscall.SIGTERM
+
+// setProcessGroup - run cmd in its own process group so children can be signaled with it
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// signalProcessGroup - send sig to every process in the group led by process
+func signalProcessGroup(process *os.Process, sig os.Signal) error {
+	s, ok := sig.(syscall.Signal)
+	if !ok {
+		return process.Signal(sig)
+	}
+	return syscall.Kill(-process.Pid, s)
+}
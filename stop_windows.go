@@ -0,0 +1,20 @@
+//go:build windows
+// +build windows
+
+package transcoder
+
+import (
+	"os"
+	"os/exec"
+)
+
+// Windows has no SIGTERM, Signal returns an error and stop escalates to Kill
+var sigTerm os.Signal = os.Interrupt
+
+// setProcessGroup - process groups are not supported
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// signalProcessGroup - only the process itself can be signaled
+func signalProcessGroup(process *os.Process, sig os.Signal) error {
+	return process.Signal(sig)
+}
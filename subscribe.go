@@ -0,0 +1,120 @@
+package transcoder
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// JobEvent types, progress and log are also sent to Job subscribers
+const (
+	JobEventQueued    = "queued"
+	JobEventStarted   = "started"
+	JobEventProgress  = "progress"
+	JobEventLog       = "log"
+	JobEventRetried   = "retried"
+	JobEventSucceeded = "succeeded"
+	JobEventFailed    = "failed"
+	JobEventKilled    = "killed"
+
+	StreamStdout = "stdout"
+	StreamStderr = "stderr"
+)
+
+// ProgressInterval - minimum time between progress events sent to subscribers
+// The final progress=end block is always sent
+var ProgressInterval = 500 * time.Millisecond
+
+// JobEvent - live update sent to Job and EventBus subscribers
+type JobEvent struct {
+	Type     string    `json:"type"`
+	JobID    uuid.UUID `json:"jobId"`
+	Time     time.Time `json:"time"`
+	Progress *Progress `json:"progress,omitempty"`
+	Stream   string    `json:"stream,omitempty"`
+	Line     string    `json:"line,omitempty"`
+
+	// Set on lifecycle events, see StatusEventTypes
+	Status    string    `json:"status,omitempty"`
+	Message   string    `json:"message,omitempty"`
+	Worker    string    `json:"worker,omitempty"`
+	Attempt   int       `json:"attempt,omitempty"`
+	Exit      *ExitInfo `json:"exit,omitempty"`
+	ErrorCode ErrorCode `json:"errorCode,omitempty"`
+	Job       *Job      `json:"job,omitempty"` // copy taken when the event was published
+}
+
+// Subscribe - receive throttled progress events and log lines while the job runs
+// The channel is closed once the job is done, after any retries, or when the returned func is called
+// Events are dropped for subscribers that fall more than DefaultEventBuffer events behind
+func (job *Job) Subscribe() (<-chan JobEvent, func()) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+
+	events := make(chan JobEvent, DefaultEventBuffer)
+	if job.isDone() {
+		close(events)
+		return events, func() {}
+	}
+	if job.subscribers == nil {
+		job.subscribers = make(map[int]chan JobEvent)
+	}
+	id := job.nextSubscriber
+	job.nextSubscriber++
+	job.subscribers[id] = events
+
+	unsubscribe := func() {
+		job.mu.Lock()
+		defer job.mu.Unlock()
+		if sub, ok := job.subscribers[id]; ok {
+			delete(job.subscribers, id)
+			close(sub)
+		}
+	}
+	return events, unsubscribe
+}
+
+// isDone - true once the job is done and won't be retried
+// job.mu must be held by the caller
+func (job *Job) isDone() bool {
+	return job.done != nil && isClosed(job.done)
+}
+
+// hasExited - true if the last run has finished
+// job.mu must be held by the caller
+func (job *Job) hasExited() bool {
+	return job.exited != nil && isClosed(job.exited)
+}
+
+// publish - send event to every subscriber without blocking, returns it for the EventBus
+// job.mu must be held by the caller
+func (job *Job) publish(event JobEvent) JobEvent {
+	event.JobID = job.ID
+	event.Time = time.Now()
+	for _, sub := range job.subscribers {
+		select {
+		case sub <- event:
+		default:
+		}
+	}
+	return event
+}
+
+// publishProgress - send a progress event unless one was sent within ProgressInterval
+// job.mu must be held by the caller
+func (job *Job) publishProgress(progress Progress) (JobEvent, bool) {
+	if !progress.Done && time.Since(job.lastProgressEvent) < ProgressInterval {
+		return JobEvent{}, false
+	}
+	job.lastProgressEvent = time.Now()
+	return job.publish(JobEvent{Type: JobEventProgress, Progress: &progress}), true
+}
+
+// closeSubscribers - close and remove all subscriber channels
+// job.mu must be held by the caller
+func (job *Job) closeSubscribers() {
+	for id, sub := range job.subscribers {
+		delete(job.subscribers, id)
+		close(sub)
+	}
+}
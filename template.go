@@ -0,0 +1,141 @@
+package transcoder
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// placeholderReg - {{name}}, {{name|default}}, {{name|filter|filter}} anywhere in a string
+var placeholderReg = regexp.MustCompile(`{{([^{}]*)}}`)
+
+// Filters - transforms usable in placeholders, applied left to right
+// Example: {{input|basename|lower}}
+var Filters = map[string]func(string) string{
+	"basename": filepath.Base,
+	"ext":      filepath.Ext,
+	"dir":      filepath.Dir,
+	"lower":    strings.ToLower,
+}
+
+// identifierReg - names such as filters and environment variables
+// Bare placeholder words like these are read as filters, defaults that look like one need quotes
+var identifierReg = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// TemplateError - a placeholder that can't render whatever the params, such as one with an unknown filter
+type TemplateError struct {
+	Name        string // param the placeholder reads
+	Placeholder string
+	Message     string
+}
+
+func (e *TemplateError) Error() string {
+	return fmt.Sprintf("placeholder {{%s}}: %s", e.Placeholder, e.Message)
+}
+
+// MissingParamError - placeholders without a job param or default
+type MissingParamError struct {
+	Names []string
+}
+
+func (e *MissingParamError) Error() string {
+	return fmt.Sprintf("missing params %s", strings.Join(e.Names, ", "))
+}
+
+// Render - replace every placeholder in s with job params
+// After the param name, each "|" section is either a filter name or a default value used when the param is missing.
+// Bare words must be filters, quote defaults that look like one: {{codec|"h264"}}. {{name|}} renders missing params as ""
+// Unknown filters and extra defaults return a *TemplateError
+func Render(s string, params JobParams) (string, error) {
+	var missing []string
+	var templateErr error
+	rendered := placeholderReg.ReplaceAllStringFunc(s, func(match string) string {
+		expr := placeholderReg.FindStringSubmatch(match)[1]
+		value, ok, err := renderPlaceholder(expr, params)
+		switch {
+		case err != nil:
+			if templateErr == nil {
+				templateErr = err
+			}
+		case !ok:
+			missing = append(missing, strings.TrimSpace(strings.Split(expr, "|")[0]))
+		}
+		return value
+	})
+	if templateErr != nil {
+		return "", templateErr
+	}
+	if len(missing) > 0 {
+		return "", &MissingParamError{Names: missing}
+	}
+	return rendered, nil
+}
+
+// RenderAll - Render every string in list, collecting all missing params into one error
+func RenderAll(list []string, params JobParams) ([]string, error) {
+	rendered := make([]string, len(list))
+	missing := map[string]bool{}
+	for i, s := range list {
+		r, err := Render(s, params)
+		if missingErr, ok := err.(*MissingParamError); ok {
+			for _, name := range missingErr.Names {
+				missing[name] = true
+			}
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		rendered[i] = r
+	}
+	if len(missing) > 0 {
+		names := make([]string, 0, len(missing))
+		for name := range missing {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, &MissingParamError{Names: names}
+	}
+	return rendered, nil
+}
+
+// renderPlaceholder - resolve the inside of a single {{...}}
+// ok is false when the param is missing and there is no default
+func renderPlaceholder(expr string, params JobParams) (value string, ok bool, err error) {
+	parts := strings.Split(expr, "|")
+	name := strings.TrimSpace(parts[0])
+
+	var filters []func(string) string
+	var def *string
+	for _, part := range parts[1:] {
+		part = strings.TrimSpace(part)
+		if filter, ok := Filters[part]; ok {
+			filters = append(filters, filter)
+			continue
+		}
+		if identifierReg.MatchString(part) {
+			return "", false, &TemplateError{Name: name, Placeholder: expr, Message: fmt.Sprintf("unknown filter %q, quote it if it's a default", part)}
+		}
+		if def != nil {
+			return "", false, &TemplateError{Name: name, Placeholder: expr, Message: fmt.Sprintf("more than one default, %q and %q", *def, part)}
+		}
+		d := part
+		if len(d) >= 2 && strings.HasPrefix(d, `"`) && strings.HasSuffix(d, `"`) {
+			d = d[1 : len(d)-1]
+		}
+		def = &d
+	}
+
+	value, ok = params[name]
+	if !ok {
+		if def == nil {
+			return "", false, nil
+		}
+		value = *def
+	}
+	for _, filter := range filters {
+		value = filter(value)
+	}
+	return value, true, nil
+}
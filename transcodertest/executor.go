@@ -0,0 +1,249 @@
+// Package transcodertest provides a fake Executor so jobs, workers and servers can be tested without ffmpeg
+package transcodertest
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/palmdalian/transcoder"
+)
+
+// Script - what a fake ffmpeg run prints and how it ends
+type Script struct {
+	Duration  time.Duration // reported on stderr as "Duration:", zero leaves it out
+	Steps     int           // -progress blocks written to stdout, the last one is progress=end
+	StepDelay time.Duration // wait before each block
+	Speed     float64       // reported speed, zero uses 1
+
+	Stdout   []string // extra stdout lines written before exiting
+	Stderr   []string // extra stderr lines written before exiting
+	ExitCode int      // non-zero exits fail with an *ExitError
+	StartErr error    // returned from Start instead of running
+
+	Hang            bool // keep running after the last step until stopped
+	IgnoreInterrupt bool // keep running on SIGINT, SIGTERM and "q" so only SIGKILL stops it
+}
+
+// Progress - typical stream copy to mp4 of a 10 second input
+var Progress = Script{Duration: 10 * time.Second, Steps: 5, StepDelay: 10 * time.Millisecond, Speed: 2}
+
+// Probe - ffprobe output for a 1080p h264 input with stereo aac audio lasting duration
+func Probe(duration time.Duration) Script {
+	return Script{Stdout: []string{fmt.Sprintf(`{
+	"streams": [
+		{"index": 0, "codec_name": "h264", "profile": "High", "codec_type": "video", "width": 1920, "height": 1080, "pix_fmt": "yuv420p",
+			"r_frame_rate": "30000/1001", "avg_frame_rate": "30000/1001", "duration": "%[1]f", "bit_rate": "4000000"},
+		{"index": 1, "codec_name": "aac", "codec_type": "audio", "sample_rate": "48000", "channels": 2, "channel_layout": "stereo",
+			"r_frame_rate": "0/0", "avg_frame_rate": "0/0", "duration": "%[1]f", "bit_rate": "128000"}
+	],
+	"format": {"filename": "in.mov", "format_name": "mov,mp4,m4a,3gp,3g2,mj2", "duration": "%[1]f", "size": "5000000", "bit_rate": "4128000"}
+}`, duration.Seconds())}}
+}
+
+// ExitError - returned from Wait when the script exits non-zero
+type ExitError struct {
+	Code   int
+	Signal string
+}
+
+func (e *ExitError) Error() string {
+	if e.Signal != "" {
+		return fmt.Sprintf("signal: %s", e.Signal)
+	}
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+// Executor - transcoder.Executor that runs Scripts in-process
+type Executor struct {
+	// Script - choose what to run for cmd. Defaults to the script given to NewExecutor
+	Script func(cmd *transcoder.Command) Script
+
+	mu       sync.Mutex
+	commands []*transcoder.Command
+}
+
+// NewExecutor - every command runs script
+func NewExecutor(script Script) *Executor {
+	return &Executor{Script: func(*transcoder.Command) Script { return script }}
+}
+
+// Commands - every command started so far
+func (e *Executor) Commands() []*transcoder.Command {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]*transcoder.Command{}, e.commands...)
+}
+
+func (e *Executor) Start(ctx context.Context, cmd *transcoder.Command, stdin io.Reader) (transcoder.Process, error) {
+	e.mu.Lock()
+	e.commands = append(e.commands, cmd)
+	e.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	script := e.Script(cmd)
+	if script.StartErr != nil {
+		return nil, script.StartErr
+	}
+
+	p := &process{
+		script:  script,
+		signals: make(chan os.Signal, 10),
+		exited:  make(chan struct{}),
+	}
+	var stdoutW, stderrW *io.PipeWriter
+	p.stdout, stdoutW = io.Pipe()
+	p.stderr, stderrW = io.Pipe()
+	if stdin == nil && cmd.Interactive {
+		var stdinR *io.PipeReader
+		stdinR, p.stdin = io.Pipe()
+		go p.readCommands(stdinR)
+	}
+	go p.run(stdoutW, stderrW)
+	return p, nil
+}
+
+type process struct {
+	script  Script
+	stdout  io.Reader
+	stderr  io.Reader
+	stdin   io.WriteCloser
+	signals chan os.Signal
+	exited  chan struct{}
+	exit    transcoder.ExitInfo
+	err     error
+}
+
+func (p *process) Stdout() io.Reader     { return p.stdout }
+func (p *process) Stderr() io.Reader     { return p.stderr }
+func (p *process) Stdin() io.WriteCloser { return p.stdin }
+
+func (p *process) Signal(sig os.Signal) error {
+	select {
+	case <-p.exited:
+		return errors.New("process already finished")
+	case p.signals <- sig:
+		return nil
+	}
+}
+
+func (p *process) Wait() (transcoder.ExitInfo, error) {
+	<-p.exited
+	return p.exit, p.err
+}
+
+// quit - signal used for ffmpeg's "q" command
+type quit struct{}
+
+func (quit) String() string { return "quit" }
+func (quit) Signal()        {}
+
+// readCommands - treat "q" on stdin like ffmpeg does
+func (p *process) readCommands(stdin io.Reader) {
+	scanner := bufio.NewScanner(stdin)
+	for scanner.Scan() {
+		if strings.TrimSpace(scanner.Text()) == "q" {
+			p.Signal(quit{})
+			return
+		}
+	}
+}
+
+// run - write the script to stdout and stderr until it finishes or is stopped
+func (p *process) run(stdout, stderr *io.PipeWriter) {
+	defer close(p.exited)
+	defer stdout.Close()
+	defer stderr.Close()
+	started := time.Now()
+	defer func() { p.exit.WallTime = time.Since(started) }()
+
+	fmt.Fprintln(stderr, "ffmpeg version fake Copyright (c) 2000-2021 the FFmpeg developers")
+	if p.script.Duration > 0 {
+		fmt.Fprintf(stderr, "  Duration: %s, start: 0.000000, bitrate: 1024 kb/s\n", timecode(p.script.Duration))
+	}
+
+	speed := p.script.Speed
+	if speed == 0 {
+		speed = 1
+	}
+	for step := 1; step <= p.script.Steps; step++ {
+		if p.wait(p.script.StepDelay) {
+			return
+		}
+		outTime := p.script.Duration * time.Duration(step) / time.Duration(p.script.Steps)
+		state := "continue"
+		if step == p.script.Steps && !p.script.Hang {
+			state = "end"
+		}
+		fmt.Fprintf(stdout, "frame=%d\nfps=25.00\nbitrate=1024.0kbits/s\ntotal_size=%d\nout_time_us=%d\nout_time_ms=%d\nout_time=%s\ndup_frames=0\ndrop_frames=0\nspeed=%.2fx\nprogress=%s\n",
+			int(outTime.Seconds()*25), step*1024, outTime.Microseconds(), outTime.Microseconds(), timecode(outTime), speed, state)
+	}
+	if p.script.Hang && p.wait(-1) {
+		return
+	}
+
+	for _, line := range p.script.Stdout {
+		fmt.Fprintln(stdout, line)
+	}
+	for _, line := range p.script.Stderr {
+		fmt.Fprintln(stderr, line)
+	}
+	p.exit.ExitCode = p.script.ExitCode
+	if p.script.ExitCode != 0 {
+		p.err = &ExitError{Code: p.script.ExitCode}
+	}
+}
+
+// wait - sleep for d (forever if negative) and return true if a signal ended the process
+func (p *process) wait(d time.Duration) bool {
+	var timeout <-chan time.Time
+	if d >= 0 {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	for {
+		select {
+		case <-timeout:
+			return false
+		case sig := <-p.signals:
+			if p.handle(sig) {
+				return true
+			}
+		}
+	}
+}
+
+// handle - apply a signal, returns true if the process exited
+func (p *process) handle(sig os.Signal) bool {
+	switch {
+	case sig == os.Kill:
+		p.exit = transcoder.ExitInfo{ExitCode: -1, Signal: "killed"}
+		p.err = &ExitError{Code: -1, Signal: "killed"}
+	case p.script.IgnoreInterrupt:
+		return false
+	case sig == (quit{}):
+		// ffmpeg finishes writing outputs and exits cleanly
+		p.exit = transcoder.ExitInfo{}
+	default:
+		p.exit = transcoder.ExitInfo{ExitCode: 255}
+		p.err = &ExitError{Code: 255}
+	}
+	return true
+}
+
+// timecode - format d like ffmpeg, 00:00:10.00
+func timecode(d time.Duration) string {
+	h := int(d.Hours())
+	m := int(d.Minutes()) % 60
+	s := d.Seconds() - float64(h*3600+m*60)
+	return fmt.Sprintf("%02d:%02d:%05.2f", h, m, s)
+}
@@ -0,0 +1,30 @@
+package transcodertest
+
+import (
+	"github.com/google/uuid"
+	"github.com/palmdalian/transcoder"
+)
+
+// NewPreset - ffmpeg stream copy preset taking input and output params
+func NewPreset() *transcoder.Preset {
+	return &transcoder.Preset{
+		ID:          uuid.New(),
+		Description: "Fake stream copy",
+		Path:        "ffmpeg",
+		Args:        []string{"-y", "-progress", "-", "-nostats", "-i", "{{input}}", "-c", "copy", "{{output}}"},
+		Params: []transcoder.ParamSpec{
+			{Name: "input", Type: transcoder.ParamTypePath, Required: true},
+			{Name: "output", Type: transcoder.ParamTypePath, Required: true},
+		},
+	}
+}
+
+// NewJob - job for NewPreset run by executor
+func NewJob(executor transcoder.Executor) *transcoder.Job {
+	job, err := transcoder.NewJob(NewPreset(), transcoder.JobParams{"input": "in.mov", "output": "out.mp4"})
+	if err != nil {
+		panic(err)
+	}
+	job.Executor = executor
+	return job
+}
@@ -0,0 +1,140 @@
+package transcoder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/palmdalian/transcoder/ffprobe"
+)
+
+// Verification - checks on the output once the command and post hooks succeeded
+// The output has to exist, every other check is optional. Failures fail the job with a *VerificationError
+// Existence and size are checked on this machine while ffprobe runs through the job Executor,
+// so only works when the Executor shares this machine's filesystem
+type Verification struct {
+	// Output - path to check, templated like Args. Example: "{{output}}.mp4"
+	// Empty checks the Preset.AtomicOutput param, presets without one must set it
+	Output string `json:"output"`
+	// NonEmpty - output must not be empty
+	NonEmpty bool `json:"nonEmpty,omitempty"`
+	// Probe - output must be readable by ffprobe, implied by the checks below
+	Probe bool `json:"probe,omitempty"`
+	// DurationTolerance - max seconds the output duration may differ from the input, needs Preset.ProbeInput,
+	// which then fails the job if the input can't be probed. Zero skips it
+	DurationTolerance float64 `json:"durationTolerance,omitempty"`
+	// VideoCodec and AudioCodec - codec names ffprobe must report, such as "h264" and "aac"
+	VideoCodec string `json:"videoCodec,omitempty"`
+	AudioCodec string `json:"audioCodec,omitempty"`
+	// Width and Height - video resolution, zero accepts any
+	Width  int `json:"width,omitempty"`
+	Height int `json:"height,omitempty"`
+}
+
+// ErrNoVerifyOutput - the preset Verification has no Output and the preset no AtomicOutput to default to
+var ErrNoVerifyOutput = errors.New("verify output is empty and the preset has no atomicOutput")
+
+// ErrNoProbeInput - the preset Verification has a DurationTolerance but the preset no ProbeInput to compare against
+var ErrNoProbeInput = errors.New("verify durationTolerance needs the preset probeInput")
+
+// verifyOutput - template of the path to verify, defaulting to the AtomicOutput param
+func (preset *Preset) verifyOutput() string {
+	if preset.Verify.Output == "" && preset.AtomicOutput != "" {
+		return "{{" + preset.AtomicOutput + "}}"
+	}
+	return preset.Verify.Output
+}
+
+// VerificationError - the output failed one or more Verification checks
+type VerificationError struct {
+	Path     string
+	Problems []string
+}
+
+func (e *VerificationError) Error() string {
+	return fmt.Sprintf("verifying output %s: %s", e.Path, strings.Join(e.Problems, ", "))
+}
+
+// needsProbe - true if any check reads the output with ffprobe
+func (v *Verification) needsProbe() bool {
+	return v.Probe || v.DurationTolerance > 0 || v.VideoCodec != "" || v.AudioCodec != "" || v.Width > 0 || v.Height > 0
+}
+
+// verify - run the preset Verification against the rendered output path
+func (job *Job) verify(ctx context.Context) error {
+	v := job.Preset.Verify
+	path := job.command.Verify
+	verr := &VerificationError{Path: path}
+	info, err := os.Stat(path)
+	switch {
+	case os.IsNotExist(err):
+		verr.Problems = append(verr.Problems, "does not exist")
+		return verr
+	case err != nil:
+		return fmt.Errorf("verifying output %w", err)
+	case v.NonEmpty && info.Size() == 0:
+		verr.Problems = append(verr.Problems, "is empty")
+	}
+
+	if v.needsProbe() {
+		result, err := job.probe(ctx, path, "[verify] ")
+		if err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			verr.Problems = append(verr.Problems, fmt.Sprintf("not readable by ffprobe (%v)", err))
+			return verr
+		}
+		verr.Problems = append(verr.Problems, v.check(result, job.probedDuration())...)
+	}
+
+	if len(verr.Problems) > 0 {
+		return verr
+	}
+	return nil
+}
+
+// check - problems with the probed output, inputDuration is 0 if unknown
+func (v *Verification) check(result *ffprobe.Result, inputDuration float64) []string {
+	var problems []string
+	if v.DurationTolerance > 0 {
+		duration := result.Duration()
+		switch {
+		case inputDuration == 0:
+			problems = append(problems, "input duration unknown, set the preset ProbeInput")
+		case math.Abs(duration-inputDuration) > v.DurationTolerance:
+			problems = append(problems, fmt.Sprintf("duration %.2fs differs from the input %.2fs by more than %.2fs", duration, inputDuration, v.DurationTolerance))
+		}
+	}
+
+	if v.VideoCodec != "" || v.Width > 0 || v.Height > 0 {
+		video := result.Video()
+		if video == nil {
+			problems = append(problems, "no video stream")
+		} else {
+			if v.VideoCodec != "" && video.CodecName != v.VideoCodec {
+				problems = append(problems, fmt.Sprintf("video codec %s, want %s", video.CodecName, v.VideoCodec))
+			}
+			if v.Width > 0 && video.Width != v.Width {
+				problems = append(problems, fmt.Sprintf("width %d, want %d", video.Width, v.Width))
+			}
+			if v.Height > 0 && video.Height != v.Height {
+				problems = append(problems, fmt.Sprintf("height %d, want %d", video.Height, v.Height))
+			}
+		}
+	}
+
+	if v.AudioCodec != "" {
+		found := false
+		for _, audio := range result.Audio() {
+			found = found || audio.CodecName == v.AudioCodec
+		}
+		if !found {
+			problems = append(problems, fmt.Sprintf("no %s audio stream", v.AudioCodec))
+		}
+	}
+	return problems
+}
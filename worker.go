@@ -1,79 +1,364 @@
 package transcoder
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/google/uuid"
 )
 
+// Worker states reported in WorkerStats
+const (
+	WorkerIdle     = "idle"
+	WorkerBusy     = "busy"
+	WorkerStopping = "stopping" // removed by Pool.Resize, exits after its current job
+)
+
 type Worker struct {
-	Name           string
-	jobQueue       chan *Job
-	jobUpdatesChan chan *JobStatus // Channel for controller to handle any job updates
+	Name       string
+	Classifier Classifier   // used for jobs without their own Classifier
+	Executor   Executor     // used for jobs without their own Executor
+	Middleware []Middleware // wraps every job, the first runs outermost
+	next       func() (*Job, bool)
+	requeue    func(job *Job, wait time.Duration) error // set by Pool to free the worker during retry backoff
+	ctx        context.Context                          // canceled to stop running jobs
+	events     *EventBus                                // receives the lifecycle, progress and log events of every job
+
+	mu        sync.Mutex
+	job       *Job
+	completed int
+	failed    int
+	startedAt time.Time
+	retiring  bool
+	cost      Cost // held in the Pool budget, guarded by Pool.mu
 }
 
-// NewWorker create new worker and start consuming jobQueue
-func NewWorker(jobQueue chan *Job, jobUpdatesChan chan *JobStatus) *Worker {
-	worker := &Worker{
-		Name:           fmt.Sprintf("Worker%v", uuid.NewString()[:4]),
-		jobQueue:       jobQueue,
-		jobUpdatesChan: jobUpdatesChan,
+// outcome - how a Worker finished with a job
+type outcome int
+
+const (
+	outcomeFailed outcome = iota
+	outcomeSucceeded
+	outcomeRequeued // handed back to the Pool until its retry backoff has passed
+)
+
+// WorkerStats - snapshot of what a Worker is doing
+type WorkerStats struct {
+	Name          string        `json:"name"`
+	State         string        `json:"state"`
+	JobID         *uuid.UUID    `json:"jobId,omitempty"` // job being run
+	JobsCompleted int           `json:"jobsCompleted"`
+	JobsFailed    int           `json:"jobsFailed"`
+	StartedAt     time.Time     `json:"startedAt"`
+	Uptime        time.Duration `json:"uptime"`
+}
+
+// NewWorker create new worker and start consuming jobQueue, events may be nil
+// The worker stops once jobQueue is closed, use a Pool for graceful shutdown
+func NewWorker(jobQueue chan *Job, events *EventBus) *Worker {
+	next := func() (*Job, bool) {
+		job, ok := <-jobQueue
+		return job, ok
 	}
-	go worker.start()
+	worker := newWorker(context.Background(), next, events)
+	go func() {
+		for !worker.start() {
+			log.Printf("%s restarting after a panic", worker.Name)
+		}
+	}()
 
 	return worker
 }
 
-func (worker *Worker) start() {
-	for job := range worker.jobQueue {
-		if job.Preset == nil {
-			worker.reject(job, fmt.Sprintf("job %v does not have a preset", job.ID))
-			continue
+func newWorker(ctx context.Context, next func() (*Job, bool), events *EventBus) *Worker {
+	return &Worker{
+		Name:      fmt.Sprintf("Worker%v", uuid.NewString()[:4]),
+		next:      next,
+		ctx:       ctx,
+		events:    events,
+		startedAt: time.Now(),
+	}
+}
+
+// start - handle jobs until next has none
+// Returns false after recovering a panic so the caller can replace the worker
+func (worker *Worker) start() bool {
+	for {
+		job, ok := worker.next()
+		if !ok {
+			return true
+		}
+		worker.setJob(job)
+		result, panicked := worker.handleSafely(job)
+		worker.finishJob(result)
+		if panicked {
+			return false
 		}
+	}
+}
 
-		job.Status = JobStatusInProgress
-		worker.sendUpdate(&JobStatus{Job: job, Status: job.Status})
+// handleSafely - handle job, failing it with a PanicError if anything panics
+func (worker *Worker) handleSafely(job *Job) (result outcome, panicked bool) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		panicErr := newPanicError(r)
+		log.Printf("%s recovered %v running job %v\n%s", worker.Name, panicErr, job.ID, panicErr.Stack)
+		// CommandOutput is saved with the job, keep the stack where it can be found later
+		msg := fmt.Sprintf("%v\n%s", panicErr, panicErr.Stack)
+		job.mu.Lock()
+		job.err = panicErr
+		job.ErrorCode = ErrorCodePanic
+		job.CommandOutput = strings.TrimPrefix(job.CommandOutput+"\n"+msg, "\n")
+		job.mu.Unlock()
+		worker.reject(job, msg)
+		result, panicked = outcomeFailed, true
+	}()
+	return worker.handle(job), false
+}
 
-		log.Printf("%s got job %s", worker.Name, job.ID)
-		if err := worker.submit(job); err != nil {
-			worker.reject(job, fmt.Sprintf("submitting job %v", err))
-			continue
+// handle - run job and send its final status unless it was requeued for a retry
+// Middleware wraps every attempt
+func (worker *Worker) handle(job *Job) outcome {
+	if job.Preset == nil {
+		worker.reject(job, fmt.Sprintf("job %v does not have a preset", job.ID))
+		return outcomeFailed
+	}
+
+	log.Printf("%s got job %s", worker.Name, job.ID)
+	job.setBus(worker.events)
+	if job.Classifier == nil {
+		job.Classifier = worker.Classifier
+	}
+	if job.Executor == nil {
+		job.Executor = worker.Executor
+	}
+	for {
+		var runErr error
+		handler := chain(func(ctx context.Context, job *Job) error {
+			runErr = worker.submit(ctx, job)
+			return runErr
+		}, worker.Middleware)
+		err := handler(worker.ctx, job)
+		if err == nil {
+			job.setStatus(JobStatusDone)
+			worker.publish(job, JobEvent{Type: JobEventSucceeded, Exit: exitInfo(job)})
+			setDone(job)
+			return outcomeSucceeded
 		}
 
-		job.Status = JobStatusDone
-		worker.sendUpdate(&JobStatus{Job: job, Status: job.Status})
+		// Only failed runs are retried, not middleware errors
+		if runErr != nil {
+			if wait, ok := worker.scheduleRetry(job, runErr); ok {
+				if err = worker.waitRetry(job, wait); err == nil {
+					if worker.requeue != nil {
+						return outcomeRequeued
+					}
+					continue
+				}
+			}
+		}
+
+		// Middleware may fail a job without running it
+		if job.Err() == nil {
+			job.mu.Lock()
+			job.err = err
+			job.mu.Unlock()
+			job.classify(err)
+		}
+		worker.reject(job, fmt.Sprintf("submitting job %v (%v)", err, job.ErrorCode))
+		return outcomeFailed
 	}
 }
 
-func (worker *Worker) submit(job *Job) error {
-	if err := job.Run(); err != nil {
-		return err
+func (worker *Worker) setJob(job *Job) {
+	worker.mu.Lock()
+	worker.job = job
+	worker.mu.Unlock()
+}
+
+func (worker *Worker) finishJob(result outcome) {
+	worker.mu.Lock()
+	defer worker.mu.Unlock()
+	worker.job = nil
+	switch result {
+	case outcomeSucceeded:
+		worker.completed++
+	case outcomeFailed:
+		worker.failed++
 	}
-	return nil
 }
 
-func (worker *Worker) sendUpdate(status *JobStatus) {
-	if worker.jobUpdatesChan == nil {
-		return
+// Stats - current state and counters of the worker
+func (worker *Worker) Stats() WorkerStats {
+	worker.mu.Lock()
+	defer worker.mu.Unlock()
+	stats := WorkerStats{
+		Name:          worker.Name,
+		State:         WorkerIdle,
+		JobsCompleted: worker.completed,
+		JobsFailed:    worker.failed,
+		StartedAt:     worker.startedAt,
+		Uptime:        time.Since(worker.startedAt),
+	}
+	if worker.job != nil {
+		id := worker.job.ID
+		stats.JobID = &id
+		stats.State = WorkerBusy
 	}
+	if worker.retiring {
+		stats.State = WorkerStopping
+	}
+	return stats
+}
 
-	// Encode so readers don't do anything weird with the *Job
-	b, err := json.Marshal(status)
-	if err != nil {
-		log.Printf("Problem marshaling status %v", err)
-		return
+// retire - exit after the current job
+func (worker *Worker) retire() {
+	worker.mu.Lock()
+	worker.retiring = true
+	worker.mu.Unlock()
+}
+
+func (worker *Worker) isRetiring() bool {
+	worker.mu.Lock()
+	defer worker.mu.Unlock()
+	return worker.retiring
+}
+
+func (worker *Worker) currentJob() *Job {
+	worker.mu.Lock()
+	defer worker.mu.Unlock()
+	return worker.job
+}
+
+func (worker *Worker) isBusy() bool {
+	worker.mu.Lock()
+	defer worker.mu.Unlock()
+	return worker.job != nil
+}
+
+// submit - run one attempt of job, innermost Handler of every worker
+func (worker *Worker) submit(ctx context.Context, job *Job) error {
+	attempt := job.nextAttempt()
+	job.setStatus(JobStatusInProgress)
+	worker.publish(job, JobEvent{Type: JobEventStarted, Attempt: attempt})
+
+	startedAt := time.Now()
+	err := job.run(ctx)
+	job.addAttempt(worker.Name, startedAt, err)
+	return err
+}
+
+// scheduleRetry - mark job retrying after the failed attempt err if its RetryPolicy allows another
+// Returns the backoff before the next attempt
+func (worker *Worker) scheduleRetry(job *Job, err error) (time.Duration, bool) {
+	policy := job.retryPolicy()
+	attempt := job.attempt()
+	if !policy.shouldRetry(job.ErrorCode, attempt) {
+		return 0, false
+	}
+
+	wait := policy.backoff(attempt)
+	job.setStatus(JobStatusRetrying)
+	worker.publish(job, JobEvent{
+		Type:      JobEventRetried,
+		Message:   fmt.Sprintf("attempt %d failed %v, retrying in %v", attempt, err, wait),
+		Attempt:   attempt,
+		Exit:      exitInfo(job),
+		ErrorCode: job.ErrorCode,
+	})
+	return wait, true
+}
+
+// waitRetry - hand a retrying job back to the Pool until its backoff has passed, freeing the worker and its Cost
+// Workers outside a Pool wait in place
+func (worker *Worker) waitRetry(job *Job, wait time.Duration) error {
+	if worker.requeue != nil {
+		if err := worker.requeue(job, wait); err != nil {
+			return stopRetry(job, err)
+		}
+		return nil
+	}
+	killed := make(chan struct{})
+	job.setCancelRetry(func() bool {
+		close(killed)
+		return true
+	})
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-killed:
+	case <-worker.ctx.Done():
 	}
-	toSend := &JobStatus{}
-	if err = json.Unmarshal(b, toSend); err != nil {
-		log.Printf("Problem unmarshaling status %v", err)
+	// Kill claims the backoff before closing killed, whoever claims it first decides
+	if job.takeCancelRetry() == nil {
+		return killRetry(job)
 	}
+	if err := worker.ctx.Err(); err != nil {
+		return stopRetry(job, err)
+	}
+	return nil
+}
+
+// stopRetry - cancel a retrying job that won't run again
+func stopRetry(job *Job, cause error) error {
+	err := fmt.Errorf("stopped before retry: %w", cause)
+	job.setStatus(JobStatusCanceled)
+	job.classify(err)
+	return err
+}
+
+func (worker *Worker) publish(job *Job, event JobEvent) {
+	event.Worker = worker.Name
+	publishStatus(worker.events, job, event)
+}
 
-	worker.jobUpdatesChan <- toSend
+// publishStatus - send a lifecycle event with the job status and a copy of the job
+func publishStatus(events *EventBus, job *Job, event JobEvent) {
+	if events == nil {
+		return
+	}
+	event.JobID = job.ID
+	event.Time = time.Now()
+	event.Status = job.Status
+	event.Job = job.Copy()
+	events.Publish(event)
 }
 
 func (worker *Worker) reject(job *Job, msg string) {
-	job.Status = JobStatusFailed
-	worker.sendUpdate(&JobStatus{Job: job, Status: job.Status, Message: msg})
+	rejectJob(worker.events, job, JobEvent{Worker: worker.Name, Message: msg})
+}
+
+// rejectJob - mark job failed unless it timed out or was canceled, and release its waiters
+// Killed jobs are published as JobEventKilled, everything else as JobEventFailed
+func rejectJob(events *EventBus, job *Job, event JobEvent) {
+	// Keep timedOut and canceled so callers can tell them apart from failures
+	if status := job.status(); status != JobStatusTimedOut && status != JobStatusCanceled {
+		job.setStatus(JobStatusFailed)
+	}
+	event.Type = JobEventFailed
+	if job.ErrorCode == ErrorCodeKilled || errors.Is(job.Err(), ErrKilled) {
+		event.Type = JobEventKilled
+	}
+	event.Exit = exitInfo(job)
+	event.ErrorCode = job.ErrorCode
+	publishStatus(events, job, event)
+	setDone(job)
+}
+
+// exitInfo - copy of the job exit info if its process ran
+func exitInfo(job *Job) *ExitInfo {
+	if job.Exit.WallTime == 0 {
+		return nil
+	}
+	exit := job.Exit
+	return &exit
 }
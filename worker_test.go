@@ -0,0 +1,177 @@
+package transcoder_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/palmdalian/transcoder"
+	"github.com/palmdalian/transcoder/transcodertest"
+)
+
+func TestWorker(t *testing.T) {
+	jobQueue := make(chan *transcoder.Job, 10)
+	events := transcoder.NewEventBus()
+	updates, unsubscribe := events.Subscribe(transcoder.SubscribeOptions{Types: transcoder.StatusEventTypes})
+	defer unsubscribe()
+	worker := transcoder.NewWorker(jobQueue, events)
+	worker.Executor = transcodertest.NewExecutor(transcodertest.Progress)
+
+	job := transcodertest.NewJob(nil)
+	jobQueue <- job
+	job.Wait()
+
+	types := readEventTypes(updates)
+	want := []string{transcoder.JobEventStarted, transcoder.JobEventSucceeded}
+	if !equal(types, want) {
+		t.Errorf("events = %v, want %v", types, want)
+	}
+	if len(job.Attempts) != 1 || job.Attempts[0].Worker != worker.Name {
+		t.Errorf("Attempts = %+v, want one attempt by %v", job.Attempts, worker.Name)
+	}
+}
+
+func TestWorkerRetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		stderr   string
+		attempts int
+	}{
+		{"transient", "av_interleaved_write_frame(): No space left on device", 3},
+		{"permanent", "in.mov: Invalid data found when processing input", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jobQueue := make(chan *transcoder.Job, 10)
+			events := transcoder.NewEventBus()
+			updates, unsubscribe := events.Subscribe(transcoder.SubscribeOptions{Types: transcoder.StatusEventTypes})
+			defer unsubscribe()
+			transcoder.NewWorker(jobQueue, events)
+
+			job := transcodertest.NewJob(transcodertest.NewExecutor(transcodertest.Script{Stderr: []string{tt.stderr, "Conversion failed!"}, ExitCode: 1}))
+			job.Retry = &transcoder.RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond, TransientOnly: true}
+			jobQueue <- job
+			job.Wait()
+
+			if job.Status != transcoder.JobStatusFailed {
+				t.Errorf("Status = %q, want %q", job.Status, transcoder.JobStatusFailed)
+			}
+			if len(job.Attempts) != tt.attempts {
+				t.Errorf("got %d attempts, want %d", len(job.Attempts), tt.attempts)
+			}
+			types := readEventTypes(updates)
+			if retried := count(types, transcoder.JobEventRetried); retried != tt.attempts-1 {
+				t.Errorf("got %d retried events, want %d", retried, tt.attempts-1)
+			}
+			if last := types[len(types)-1]; last != transcoder.JobEventFailed {
+				t.Errorf("last event = %q, want %q", last, transcoder.JobEventFailed)
+			}
+		})
+	}
+}
+
+func TestWorkerRetrySubscribe(t *testing.T) {
+	jobQueue := make(chan *transcoder.Job, 10)
+	transcoder.NewWorker(jobQueue, nil)
+
+	executor := transcodertest.NewExecutor(transcodertest.Progress)
+	var mu sync.Mutex
+	attempts := 0
+	executor.Script = func(cmd *transcoder.Command) transcodertest.Script {
+		mu.Lock()
+		defer mu.Unlock()
+		if attempts++; attempts == 1 {
+			return transcodertest.Script{Stderr: []string{"No space left on device"}, ExitCode: 1}
+		}
+		return transcodertest.Progress
+	}
+	job := transcodertest.NewJob(executor)
+	job.Retry = &transcoder.RetryPolicy{MaxAttempts: 2, Backoff: time.Millisecond, TransientOnly: true}
+	events, unsubscribe := job.Subscribe()
+	defer unsubscribe()
+	jobQueue <- job
+
+	// The stream covers every attempt and ends once the job is done
+	var failedLine, progressDone bool
+	for event := range events {
+		switch {
+		case event.Type == transcoder.JobEventLog && event.Line == "No space left on device":
+			failedLine = true
+		case event.Type == transcoder.JobEventProgress && event.Progress.Done:
+			progressDone = true
+		}
+	}
+	if !failedLine || !progressDone {
+		t.Errorf("failed attempt logged %v, retry finished %v, want events from both attempts", failedLine, progressDone)
+	}
+	if job.Status != transcoder.JobStatusDone {
+		t.Errorf("Status = %q, want %q", job.Status, transcoder.JobStatusDone)
+	}
+	if job.ErrorCode != transcoder.ErrorCodeNone {
+		t.Errorf("ErrorCode = %q after a successful retry, want none", job.ErrorCode)
+	}
+}
+
+func TestWorkerKillRetrying(t *testing.T) {
+	jobQueue := make(chan *transcoder.Job, 10)
+	events := transcoder.NewEventBus()
+	updates, unsubscribe := events.Subscribe(transcoder.SubscribeOptions{Types: transcoder.StatusEventTypes})
+	defer unsubscribe()
+	transcoder.NewWorker(jobQueue, events)
+
+	job := transcodertest.NewJob(transcodertest.NewExecutor(transcodertest.Script{Stderr: []string{"No space left on device", "Conversion failed!"}, ExitCode: 1}))
+	job.Retry = &transcoder.RetryPolicy{MaxAttempts: 3, Backoff: time.Minute, TransientOnly: true}
+	jobQueue <- job
+	for event := range updates {
+		if event.Type == transcoder.JobEventRetried {
+			break
+		}
+	}
+
+	if err := job.Kill(); err != nil {
+		t.Fatalf("Kill() = %v during backoff", err)
+	}
+	select {
+	case <-job.Done():
+	case <-time.After(time.Second):
+		t.Fatal("job not done after Kill")
+	}
+	if job.ErrorCode != transcoder.ErrorCodeKilled || len(job.Attempts) != 1 {
+		t.Errorf("ErrorCode = %q after %d attempts, want %q after 1", job.ErrorCode, len(job.Attempts), transcoder.ErrorCodeKilled)
+	}
+}
+
+// readEventTypes - types of the events already buffered for a subscriber
+func readEventTypes(events <-chan transcoder.JobEvent) []string {
+	var types []string
+	for {
+		select {
+		case event := <-events:
+			types = append(types, event.Type)
+		default:
+			return types
+		}
+	}
+}
+
+func count(a []string, s string) int {
+	n := 0
+	for _, v := range a {
+		if v == s {
+			n++
+		}
+	}
+	return n
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}